@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// awsConfigSection is a section of the shared AWS config file, e.g. [profile dev] or [sso-session my-sso].
+type awsConfigSection struct {
+	Header string
+	Keys   [][2]string
+}
+
+func (s awsConfigSection) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]\n", s.Header)
+	for _, kv := range s.Keys {
+		fmt.Fprintf(&b, "%s = %s\n", kv[0], kv[1])
+	}
+	return b.String()
+}
+
+// awsConfigFilePath returns the path of the shared AWS config file, taking AWS_CONFIG_FILE into account.
+func awsConfigFilePath() (string, error) {
+	if path := os.Getenv("AWS_CONFIG_FILE"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws", "config"), nil
+}
+
+// writeAWSConfigSections writes the sections to the shared AWS config file. Existing sections with the same header
+// are replaced in place, all other content of the file is kept as it is. New sections are appended.
+func writeAWSConfigSections(sections []awsConfigSection) (string, error) {
+	path, err := awsConfigFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(mergeAWSConfigSections(string(content), sections)), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// mergeAWSConfigSections returns the content of a shared AWS config file with the sections replaced or appended.
+// Comments directly above the next header belong to that section and are kept when the section before is replaced.
+func mergeAWSConfigSections(content string, sections []awsConfigSection) string {
+	pending := map[string]awsConfigSection{}
+	var order []string
+	for _, s := range sections {
+		if _, ok := pending[s.Header]; !ok {
+			order = append(order, s.Header)
+		}
+		pending[s.Header] = s
+	}
+
+	var out, skipped []string
+	skipping := false
+	// keepTrailingComments ends the replaced section, keeping the comments at its end
+	keepTrailingComments := func() {
+		i := len(skipped)
+		for i > 0 && isAWSConfigComment(skipped[i-1]) {
+			i--
+		}
+		for _, line := range skipped[i:] {
+			if strings.TrimSpace(line) != "" || len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" {
+				out = append(out, line)
+			}
+		}
+		skipped = nil
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		if header, ok := awsConfigHeader(line); ok {
+			if skipping {
+				keepTrailingComments()
+			}
+			s, replace := pending[header]
+			skipping = replace
+			if replace {
+				out = append(out, strings.TrimRight(s.String(), "\n"), "")
+				delete(pending, header)
+				continue
+			}
+		}
+		if skipping {
+			skipped = append(skipped, line)
+		} else {
+			out = append(out, line)
+		}
+	}
+	if skipping {
+		keepTrailingComments()
+	}
+
+	// drop blank lines left over at the end before appending
+	for len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+		out = out[:len(out)-1]
+	}
+	for _, header := range order {
+		if s, ok := pending[header]; ok {
+			if len(out) > 0 {
+				out = append(out, "")
+			}
+			out = append(out, strings.TrimRight(s.String(), "\n"))
+		}
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// awsConfigHeader returns the normalized header of a section line like [profile  dev] # comment.
+func awsConfigHeader(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "[") {
+		return "", false
+	}
+	end := strings.Index(trimmed, "]")
+	if end < 0 {
+		return "", false
+	}
+	if rest := strings.TrimSpace(trimmed[end+1:]); rest != "" && !isAWSConfigComment(rest) {
+		return "", false
+	}
+	return strings.Join(strings.Fields(trimmed[1:end]), " "), true
+}
+
+// isAWSConfigComment reports whether the line is blank or a comment.
+func isAWSConfigComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";")
+}
+
+// awsConfigSessionNames returns the names of the [sso-session] sections of the shared AWS config file.
+func awsConfigSessionNames() ([]string, error) {
+	path, err := awsConfigFilePath()
+	if err != nil {
+		return nil, err
+	}
+	sections, err := readINIFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, section := range sections {
+		if name, ok := strings.CutPrefix(section.Name, "sso-session "); ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
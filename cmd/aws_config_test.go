@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeAWSConfigSections(t *testing.T) {
+	dev := awsConfigSection{Header: "profile dev", Keys: [][2]string{{"sso_session", "terra3"}, {"region", "eu-central-1"}}}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "empty file",
+			content: "",
+			want:    "[profile dev]\nsso_session = terra3\nregion = eu-central-1\n",
+		},
+		{
+			name:    "append",
+			content: "[default]\nregion = us-east-1\n\n\n",
+			want:    "[default]\nregion = us-east-1\n\n[profile dev]\nsso_session = terra3\nregion = eu-central-1\n",
+		},
+		{
+			name:    "replace in place",
+			content: "[default]\nregion = us-east-1\n\n[profile  dev]\nregion = us-west-2\noutput = json\n\n[profile prod]\nregion = us-east-1\n",
+			want:    "[default]\nregion = us-east-1\n\n[profile dev]\nsso_session = terra3\nregion = eu-central-1\n\n[profile prod]\nregion = us-east-1\n",
+		},
+		{
+			name:    "replace last section",
+			content: "[profile dev]\nregion = us-west-2\n",
+			want:    "[profile dev]\nsso_session = terra3\nregion = eu-central-1\n",
+		},
+		{
+			name:    "unrelated sections untouched",
+			content: "[profile prod]\n# production\nregion = us-east-1\ns3 =\n  max_concurrent_requests = 20\n",
+			want:    "[profile prod]\n# production\nregion = us-east-1\ns3 =\n  max_concurrent_requests = 20\n\n[profile dev]\nsso_session = terra3\nregion = eu-central-1\n",
+		},
+		{
+			name:    "comments of the next section kept",
+			content: "[profile dev]\nregion = us-west-2\n\n# production account\n; do not change\n[profile prod]\nregion = us-east-1\n",
+			want:    "[profile dev]\nsso_session = terra3\nregion = eu-central-1\n\n# production account\n; do not change\n[profile prod]\nregion = us-east-1\n",
+		},
+		{
+			name:    "header with comment",
+			content: "[profile dev] # managed by terra3\nregion = us-west-2\n\n[profile prod] ; production\nregion = us-east-1\n",
+			want:    "[profile dev]\nsso_session = terra3\nregion = eu-central-1\n\n[profile prod] ; production\nregion = us-east-1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeAWSConfigSections(tt.content, []awsConfigSection{dev})
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+			if strings.Count(got, "[profile dev]") != 1 {
+				t.Errorf("section written %d times", strings.Count(got, "[profile dev]"))
+			}
+		})
+	}
+}
+
+func TestWriteAWSConfigSectionsMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aws", "config")
+	t.Setenv("AWS_CONFIG_FILE", path)
+
+	session := awsConfigSection{Header: "sso-session terra3", Keys: [][2]string{{"sso_start_url", "https://example.awsapps.com/start"}}}
+	written, err := writeAWSConfigSections([]awsConfigSection{session})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if written != path {
+		t.Errorf("path = %q, want %q", written, path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[sso-session terra3]\nsso_start_url = https://example.awsapps.com/start\n"; string(content) != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+	names, err := awsConfigSessionNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "terra3" {
+		t.Errorf("session names = %v, want [terra3]", names)
+	}
+}
+
+func TestSSOCachedTokenRegistrationExpiresAt(t *testing.T) {
+	b, err := json.Marshal(ssoCachedToken{AccessToken: "token", ExpiresAt: time.Unix(0, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "registrationExpiresAt") {
+		t.Errorf("unset registration expiry written: %s", b)
+	}
+}
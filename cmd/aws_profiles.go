@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// iniSection is a section of an INI file as used by the shared AWS config and credentials files.
+type iniSection struct {
+	Name string
+	Keys map[string]string
+}
+
+// parseINI parses the sections of an INI file. Comments starting with # or ; and blank lines are ignored, as
+// are the indented lines of nested settings like s3 = ..., which no profile detail shown here uses.
+func parseINI(content []byte) ([]iniSection, error) {
+	var sections []iniSection
+	var current *iniSection
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "["):
+			end := strings.Index(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated section header", lineNo)
+			}
+			name := strings.Join(strings.Fields(line[1:end]), " ")
+			sections = append(sections, iniSection{Name: name, Keys: map[string]string{}})
+			current = &sections[len(sections)-1]
+		case raw[0] == ' ' || raw[0] == '\t':
+			// value of a nested setting
+			continue
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+			}
+			if current == nil {
+				return nil, fmt.Errorf("line %d: key outside of a section", lineNo)
+			}
+			current.Keys[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	return sections, scanner.Err()
+}
+
+// readINIFile parses the INI file at the path. A missing file has no sections.
+func readINIFile(path string) ([]iniSection, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sections, err := parseINI(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sections, nil
+}
+
+// awsCredentialsFilePath returns the path of the shared AWS credentials file, taking AWS_SHARED_CREDENTIALS_FILE
+// into account.
+func awsCredentialsFilePath() (string, error) {
+	if path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws", "credentials"), nil
+}
+
+// awsProfile is a profile of the shared AWS config and credentials files.
+type awsProfile struct {
+	Name              string
+	Region            string
+	SSOSession        string
+	SSOStartURL       string
+	SSOAccountID      string
+	SSORoleName       string
+	RoleARN           string
+	SourceProfile     string
+	CredentialProcess string
+	StaticCredentials bool
+}
+
+// Details describes where the credentials of the profile come from.
+func (p awsProfile) Details() string {
+	var details []string
+	switch {
+	case p.SSOSession != "" || p.SSOStartURL != "":
+		sso := "sso " + p.SSOSession
+		if p.SSOSession == "" {
+			sso = "sso " + p.SSOStartURL
+		}
+		if p.SSOAccountID != "" {
+			sso += fmt.Sprintf(" %s/%s", p.SSOAccountID, p.SSORoleName)
+		}
+		details = append(details, sso)
+	case p.RoleARN != "":
+		role := "role " + p.RoleARN
+		if p.SourceProfile != "" {
+			role += " via " + p.SourceProfile
+		}
+		details = append(details, role)
+	case p.CredentialProcess != "":
+		details = append(details, "credential_process")
+	case p.StaticCredentials:
+		details = append(details, "static credentials")
+	}
+	if p.Region != "" {
+		details = append(details, p.Region)
+	}
+	return strings.Join(details, ", ")
+}
+
+// Matches reports whether the name of the profile matches the pattern. Patterns with wildcards are matched as
+// globs, others as substrings.
+func (p awsProfile) Matches(pattern string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, _ := path.Match(pattern, p.Name)
+		return ok
+	}
+	return strings.Contains(p.Name, pattern)
+}
+
+// loadAWSProfiles returns the profiles of the shared AWS config and credentials files, sorted by name. Profiles
+// appearing in both files are merged.
+func loadAWSProfiles() ([]awsProfile, error) {
+	configPath, err := awsConfigFilePath()
+	if err != nil {
+		return nil, err
+	}
+	configSections, err := readINIFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialsPath, err := awsCredentialsFilePath()
+	if err != nil {
+		return nil, err
+	}
+	credentialsSections, err := readINIFile(credentialsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := map[string]*awsProfile{}
+	profileFor := func(name string) *awsProfile {
+		if profiles[name] == nil {
+			profiles[name] = &awsProfile{Name: name}
+		}
+		return profiles[name]
+	}
+
+	for _, section := range configSections {
+		// the config file names profiles [profile name], except for [default]
+		name, ok := strings.CutPrefix(section.Name, "profile ")
+		if !ok && section.Name != "default" {
+			continue
+		}
+		p := profileFor(name)
+		p.Region = section.Keys["region"]
+		p.SSOSession = section.Keys["sso_session"]
+		p.SSOStartURL = section.Keys["sso_start_url"]
+		p.SSOAccountID = section.Keys["sso_account_id"]
+		p.SSORoleName = section.Keys["sso_role_name"]
+		p.RoleARN = section.Keys["role_arn"]
+		p.SourceProfile = section.Keys["source_profile"]
+		p.CredentialProcess = section.Keys["credential_process"]
+	}
+
+	for _, section := range credentialsSections {
+		p := profileFor(section.Name)
+		if section.Keys["aws_access_key_id"] != "" {
+			p.StaticCredentials = true
+		}
+		// settings in the credentials file take precedence, as in the SDKs
+		if region := section.Keys["region"]; region != "" {
+			p.Region = region
+		}
+	}
+
+	result := make([]awsProfile, 0, len(profiles))
+	for _, p := range profiles {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseINI(t *testing.T) {
+	content := `# shared config
+[default]
+region = us-east-1
+
+[profile  dev]
+; comment
+Region=eu-central-1
+credential_process = /usr/bin/creds --query=role --format json
+s3 =
+  max_concurrent_requests = 20
+  addressing_style = path
+
+[sso-session acme]
+sso_start_url = https://acme.awsapps.com/start
+`
+	sections, err := parseINI([]byte(content))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	want := []iniSection{
+		{Name: "default", Keys: map[string]string{"region": "us-east-1"}},
+		{Name: "profile dev", Keys: map[string]string{
+			"region":             "eu-central-1",
+			"credential_process": "/usr/bin/creds --query=role --format json",
+			"s3":                 "",
+		}},
+		{Name: "sso-session acme", Keys: map[string]string{"sso_start_url": "https://acme.awsapps.com/start"}},
+	}
+	if !reflect.DeepEqual(sections, want) {
+		t.Errorf("sections = %v, want %v", sections, want)
+	}
+}
+
+func TestParseINIErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unterminated header", "[profile dev\nregion = us-east-1\n"},
+		{"missing value", "[default]\nregion\n"},
+		{"key outside of a section", "region = us-east-1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseINI([]byte(tt.content)); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+// writeAWSFiles writes the shared config and credentials files to a temporary directory and points the SDK
+// variables at them. An empty content leaves the file missing.
+func writeAWSFiles(t *testing.T, config, credentials string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{"config": config, "credentials": credentials} {
+		path := filepath.Join(dir, name)
+		if content != "" {
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if name == "config" {
+			t.Setenv("AWS_CONFIG_FILE", path)
+		} else {
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+		}
+	}
+}
+
+func TestLoadAWSProfiles(t *testing.T) {
+	writeAWSFiles(t, `[default]
+region = us-east-1
+
+[profile dev]
+sso_session = acme
+sso_account_id = 111111111111
+sso_role_name = Admin
+region = eu-central-1
+
+[profile deploy]
+role_arn = arn:aws:iam::222222222222:role/deploy
+source_profile = ci
+
+[sso-session acme]
+sso_start_url = https://acme.awsapps.com/start
+`, `[ci]
+aws_access_key_id = AKIAEXAMPLE
+aws_secret_access_key = secret
+
+[deploy]
+region = ap-south-1
+`)
+
+	profiles, err := loadAWSProfiles()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	want := []awsProfile{
+		{Name: "ci", StaticCredentials: true},
+		{Name: "default", Region: "us-east-1"},
+		// the region of the credentials file takes precedence
+		{Name: "deploy", Region: "ap-south-1", RoleARN: "arn:aws:iam::222222222222:role/deploy", SourceProfile: "ci"},
+		{Name: "dev", Region: "eu-central-1", SSOSession: "acme", SSOAccountID: "111111111111", SSORoleName: "Admin"},
+	}
+	if !reflect.DeepEqual(profiles, want) {
+		t.Errorf("profiles = %+v, want %+v", profiles, want)
+	}
+	if details := profiles[3].Details(); details != "sso acme 111111111111/Admin, eu-central-1" {
+		t.Errorf("details = %q", details)
+	}
+}
+
+func TestLoadAWSProfilesMissingFiles(t *testing.T) {
+	writeAWSFiles(t, "", "")
+
+	profiles, err := loadAWSProfiles()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(profiles) != 0 {
+		t.Errorf("profiles = %v, want none", profiles)
+	}
+
+	// a credentials file on its own is enough
+	writeAWSFiles(t, "", "[default]\naws_access_key_id = AKIAEXAMPLE\n")
+	profiles, err = loadAWSProfiles()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if want := []awsProfile{{Name: "default", StaticCredentials: true}}; !reflect.DeepEqual(profiles, want) {
+		t.Errorf("profiles = %+v, want %+v", profiles, want)
+	}
+}
+
+func TestAWSProfileMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"staging", true},
+		{"acme", true},
+		{"prod", false},
+		{"*-admin", true},
+		{"acme-*", true},
+		{"staging-*", false},
+		{"acme-stagin?-admin", true},
+		{"[ab]cme-*", true},
+	}
+	p := awsProfile{Name: "acme-staging-admin"}
+	for _, tt := range tests {
+		if got := p.Matches(tt.pattern); got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/it-objects/terra3-cli/ssmclient"
+	"github.com/spf13/cobra"
+)
+
+// bastionCmd represents the bastion command
+var bastionCmd = &cobra.Command{
+	Use:   "bastion",
+	Short: "Interact with the bastion host of your environment.",
+	Long: `Interact with the bastion host of your environment. Use one of the sub-commands.
+	* shell: Open an interactive shell on the bastion host.
+	`,
+}
+
+var bastionShellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Open an interactive shell on the bastion host using SSM.",
+	Long: `Open an interactive shell on the bastion host using an SSM session, without the AWS CLI. The bastion host
+	is detected automatically unless given with --bastion. With --command, the command is run interactively
+	instead of a shell, e.g. --command "top". Ctrl-C is passed on to the remote shell; exit the shell to end
+	the session.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		bastionShell()
+	},
+}
+
+var shellCommand string
+
+func init() {
+	rootCmd.AddCommand(bastionCmd)
+	bastionCmd.AddCommand(bastionShellCmd)
+	bastionShellCmd.Flags().StringVarP(&bastion, "bastion", "b", "", "Optional EC2 instance ID of the bastion host. If not provided, the bastion host is detected automatically.")
+	bastionShellCmd.Flags().StringVar(&bastionTag, "bastion-tag", "", "Optional tag of the bastion host as key=value, e.g. Name=staging-bastion. Used if --bastion is not given.")
+	bastionShellCmd.Flags().StringVar(&bastionTarget, "target", "", "Optional bastion host as instance ID, tag_key:tag_value, IP address or DNS name.")
+	bastionShellCmd.Flags().StringVarP(&shellCommand, "command", "c", "", "Optional command to run instead of an interactive shell.")
+}
+
+func bastionShell() {
+	if err := requireTerminal(); err != nil {
+		log.Fatal(err)
+	}
+
+	selectProfile()
+
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	bastionHostID := detectBastionHost(ec2.NewFromConfig(cfg))
+
+	err = ssmclient.ShellPluginSession(cfg, bastionHostID, shellCommand)
+	exitOnInterrupt(err)
+	log.Fatal(err)
+}
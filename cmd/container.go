@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/it-objects/terra3-cli/ssmclient"
+	"github.com/spf13/cobra"
+)
+
+// containerCmd represents the container command
+var containerCmd = &cobra.Command{
+	Use:   "container",
+	Short: "Interact with the containers running in your environment.",
+	Long: `Interact with the ECS containers running in your environment. Use one of the sub-commands.
+	* exec: Open a shell in a running container using ECS Exec.
+	`,
+}
+
+var containerExecCmd = &cobra.Command{
+	Use:   "exec",
+	Short: "Open a shell in a running container using ECS Exec.",
+	Long: `Open a shell in a running container using ECS Exec, without the AWS CLI. Cluster, service, task and
+	container are selected from menus unless given as flags; if there is only one choice, it is taken right away.
+	Only the clusters of the environment are offered if it is identified with --tag or --cluster-prefix.
+	ECS Exec needs to be enabled for the service, e.g. with enable_execute_command in Terraform. Ctrl-C is
+	passed on to the command running in the container.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		containerExec()
+	},
+}
+
+var (
+	ecsCluster       string
+	ecsClusterPrefix string
+	ecsService       string
+	ecsTask          string
+	ecsContainer     string
+	execCommand      string
+)
+
+func init() {
+	rootCmd.AddCommand(containerCmd)
+	containerCmd.AddCommand(containerExecCmd)
+	containerExecCmd.Flags().StringVar(&ecsCluster, "cluster", "", "Optional name of the ECS cluster.")
+	containerExecCmd.Flags().StringVar(&envTag, "tag", "", "Optional tag of the ECS clusters of the environment as key=value, limiting the clusters to select from.")
+	containerExecCmd.Flags().StringVar(&ecsClusterPrefix, "cluster-prefix", "", "Optional name prefix of the ECS clusters of the environment, limiting the clusters to select from.")
+	containerExecCmd.Flags().StringVarP(&ecsService, "service", "s", "", "Optional name of the ECS service.")
+	containerExecCmd.Flags().StringVarP(&ecsTask, "task", "t", "", "Optional ID of the ECS task.")
+	containerExecCmd.Flags().StringVar(&ecsContainer, "container", "", "Optional name of the container.")
+	containerExecCmd.Flags().StringVarP(&execCommand, "command", "c", "/bin/sh", "Command to run in the container.")
+}
+
+func containerExec() {
+	if err := requireTerminal(); err != nil {
+		log.Fatal(err)
+	}
+
+	selectProfile()
+
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	client := ecs.NewFromConfig(cfg)
+
+	cluster, err := selectECSCluster(client)
+	if err != nil {
+		log.Fatalf("unable to select ECS cluster, %v", err)
+	}
+
+	service, err := selectECSService(client, cluster)
+	if err != nil {
+		log.Fatalf("unable to select ECS service, %v", err)
+	}
+	if !service.EnableExecuteCommand {
+		log.Fatalf("ECS Exec is not enabled for service %s. Enable it with enable_execute_command and redeploy the service.", aws.ToString(service.ServiceName))
+	}
+
+	task, err := selectECSTask(client, cluster, service)
+	if err != nil {
+		log.Fatalf("unable to select ECS task, %v", err)
+	}
+	if !task.EnableExecuteCommand {
+		log.Fatalf("ECS Exec is not enabled for task %s, it was started before ECS Exec was enabled. Please redeploy the service.", ecsTaskID(task))
+	}
+
+	container, err := selectECSContainer(task)
+	if err != nil {
+		log.Fatalf("unable to select container, %v", err)
+	}
+
+	out, err := client.ExecuteCommand(context.TODO(), &ecs.ExecuteCommandInput{
+		Cluster:     aws.String(cluster),
+		Task:        task.TaskArn,
+		Container:   container.Name,
+		Command:     aws.String(execCommand),
+		Interactive: true,
+	})
+	if err != nil {
+		log.Fatalf("unable to execute command, %v", err)
+	}
+
+	session := &ssm.StartSessionOutput{
+		SessionId:  out.Session.SessionId,
+		StreamUrl:  out.Session.StreamUrl,
+		TokenValue: out.Session.TokenValue,
+	}
+	target := fmt.Sprintf("ecs:%s_%s_%s", cluster, ecsTaskID(task), aws.ToString(container.RuntimeId))
+
+	err = ssmclient.AttachInteractivePluginSession(cfg, target, session)
+	exitOnInterrupt(err)
+	log.Fatal(err)
+}
+
+// selectECSCluster returns the name of the cluster given by --cluster, or lets the user select one of the clusters
+// of the environment, identified by --tag and --cluster-prefix.
+func selectECSCluster(client *ecs.Client) (string, error) {
+	if ecsCluster != "" {
+		return ecsCluster, nil
+	}
+
+	var tagKey, tagValue string
+	if envTag != "" {
+		var err error
+		if tagKey, tagValue, err = parseTag("--tag", envTag); err != nil {
+			return "", err
+		}
+	}
+
+	var arns []string
+	paginator := ecs.NewListClustersPaginator(client, &ecs.ListClustersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return "", err
+		}
+		for _, arn := range page.ClusterArns {
+			if strings.HasPrefix(arn[strings.LastIndex(arn, "/")+1:], ecsClusterPrefix) {
+				arns = append(arns, arn)
+			}
+		}
+	}
+
+	var names []string
+	if tagKey == "" {
+		for _, arn := range arns {
+			names = append(names, arn[strings.LastIndex(arn, "/")+1:])
+		}
+	} else {
+		// DescribeClusters accepts up to 100 clusters per call
+		for start := 0; start < len(arns); start += 100 {
+			end := min(start+100, len(arns))
+			resp, err := client.DescribeClusters(context.TODO(), &ecs.DescribeClustersInput{
+				Clusters: arns[start:end],
+				Include:  []ecstypes.ClusterField{ecstypes.ClusterFieldTags},
+			})
+			if err != nil {
+				return "", err
+			}
+			for _, cluster := range resp.Clusters {
+				if hasECSTag(cluster.Tags, tagKey, tagValue) {
+					names = append(names, aws.ToString(cluster.ClusterName))
+				}
+			}
+		}
+	}
+
+	if len(names) == 0 && (envTag != "" || ecsClusterPrefix != "") {
+		return "", errors.New("no ECS cluster of the environment found, please check --tag and --cluster-prefix")
+	}
+
+	idx, err := selectOne("Select ECS cluster", names, "--cluster")
+	if err != nil {
+		return "", err
+	}
+	return names[idx], nil
+}
+
+// selectECSService returns the service given by --service, or lets the user select one of the cluster.
+func selectECSService(client *ecs.Client, cluster string) (ecstypes.Service, error) {
+	var arns []string
+	if ecsService != "" {
+		arns = []string{ecsService}
+	} else {
+		paginator := ecs.NewListServicesPaginator(client, &ecs.ListServicesInput{Cluster: aws.String(cluster)})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return ecstypes.Service{}, err
+			}
+			arns = append(arns, page.ServiceArns...)
+		}
+	}
+
+	var services []ecstypes.Service
+	// DescribeServices accepts up to 10 services per call
+	for start := 0; start < len(arns); start += 10 {
+		end := min(start+10, len(arns))
+		resp, err := client.DescribeServices(context.TODO(), &ecs.DescribeServicesInput{
+			Cluster:  aws.String(cluster),
+			Services: arns[start:end],
+		})
+		if err != nil {
+			return ecstypes.Service{}, err
+		}
+		services = append(services, resp.Services...)
+	}
+
+	if ecsService != "" && len(services) == 0 {
+		return ecstypes.Service{}, fmt.Errorf("service %s not found in cluster %s", ecsService, cluster)
+	}
+
+	items := make([]string, len(services))
+	for i, service := range services {
+		execStatus := "exec disabled"
+		if service.EnableExecuteCommand {
+			execStatus = "exec enabled"
+		}
+		items[i] = fmt.Sprintf("%-40s | %d/%d running | %s", aws.ToString(service.ServiceName), service.RunningCount, service.DesiredCount, execStatus)
+	}
+
+	idx, err := selectOne("Select ECS service", items, "--service")
+	if err != nil {
+		return ecstypes.Service{}, err
+	}
+	return services[idx], nil
+}
+
+// selectECSTask returns the task given by --task, or lets the user select one of the running tasks of the service.
+func selectECSTask(client *ecs.Client, cluster string, service ecstypes.Service) (ecstypes.Task, error) {
+	var arns []string
+	if ecsTask != "" {
+		arns = []string{ecsTask}
+	} else {
+		paginator := ecs.NewListTasksPaginator(client, &ecs.ListTasksInput{
+			Cluster:       aws.String(cluster),
+			ServiceName:   service.ServiceName,
+			DesiredStatus: ecstypes.DesiredStatusRunning,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return ecstypes.Task{}, err
+			}
+			arns = append(arns, page.TaskArns...)
+		}
+	}
+
+	if len(arns) == 0 {
+		return ecstypes.Task{}, fmt.Errorf("no running tasks found for service %s", aws.ToString(service.ServiceName))
+	}
+
+	var tasks []ecstypes.Task
+	// DescribeTasks accepts up to 100 tasks per call
+	for start := 0; start < len(arns); start += 100 {
+		end := min(start+100, len(arns))
+		resp, err := client.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
+			Cluster: aws.String(cluster),
+			Tasks:   arns[start:end],
+		})
+		if err != nil {
+			return ecstypes.Task{}, err
+		}
+		tasks = append(tasks, resp.Tasks...)
+	}
+
+	if len(tasks) == 0 {
+		return ecstypes.Task{}, fmt.Errorf("task %s not found in cluster %s", ecsTask, cluster)
+	}
+
+	items := make([]string, len(tasks))
+	for i, task := range tasks {
+		started := "-"
+		if task.StartedAt != nil {
+			started = task.StartedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		items[i] = fmt.Sprintf("%-32s | %-10s | started %s", ecsTaskID(task), aws.ToString(task.LastStatus), started)
+	}
+
+	idx, err := selectOne("Select ECS task", items, "--task")
+	if err != nil {
+		return ecstypes.Task{}, err
+	}
+	return tasks[idx], nil
+}
+
+// selectECSContainer returns the container given by --container, or lets the user select one of the task.
+func selectECSContainer(task ecstypes.Task) (ecstypes.Container, error) {
+	containers := task.Containers
+	if ecsContainer != "" {
+		containers = nil
+		for _, container := range task.Containers {
+			if aws.ToString(container.Name) == ecsContainer {
+				containers = append(containers, container)
+			}
+		}
+		if len(containers) == 0 {
+			return ecstypes.Container{}, fmt.Errorf("container %s not found in task %s", ecsContainer, ecsTaskID(task))
+		}
+	}
+
+	items := make([]string, len(containers))
+	for i, container := range containers {
+		agentStatus := "exec agent not running"
+		for _, agent := range container.ManagedAgents {
+			if agent.Name == ecstypes.ManagedAgentNameExecuteCommandAgent && aws.ToString(agent.LastStatus) == "RUNNING" {
+				agentStatus = "exec agent running"
+			}
+		}
+		items[i] = fmt.Sprintf("%-30s | %-10s | %s", aws.ToString(container.Name), aws.ToString(container.LastStatus), agentStatus)
+	}
+
+	idx, err := selectOne("Select container", items, "--container")
+	if err != nil {
+		return ecstypes.Container{}, err
+	}
+	return containers[idx], nil
+}
+
+// ecsTaskID returns the ID of the task, which is the last part of its ARN.
+func ecsTaskID(task ecstypes.Task) string {
+	arn := aws.ToString(task.TaskArn)
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
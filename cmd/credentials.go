@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/spf13/cobra"
+)
+
+// credentialsCmd represents the credentials command
+var credentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "Print role credentials from the SSO login for use as credential_process.",
+	Long: `Print the credentials of a role from the session of "terra3 login" in the JSON format of credential_process.
+	This makes the built-in login usable by every tool using the AWS SDKs, e.g. with this profile in ~/.aws/config:
+
+	[profile staging]
+	credential_process = terra3 credentials --account 123456789012 --role AdministratorAccess
+
+	The SSO session is taken from --sso-session, or from the AWS config if it contains exactly one. An expired
+	access token is refreshed if possible. Role credentials are cached and only fetched again shortly before they
+	expire. IAM Identity Center is called in the region of the login, unless --region is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printProcessCredentials()
+	},
+}
+
+var (
+	ssoAccountID   string
+	ssoRoleName    string
+	ssoSessionName string
+)
+
+// roleCredentialsRefreshWindow is how long before their expiration cached role credentials are replaced.
+const roleCredentialsRefreshWindow = 10 * time.Minute
+
+func init() {
+	rootCmd.AddCommand(credentialsCmd)
+	credentialsCmd.Flags().StringVar(&ssoAccountID, "account", "", "ID of the AWS account.")
+	credentialsCmd.Flags().StringVar(&ssoRoleName, "role", "", "Name of the role in the account.")
+	credentialsCmd.Flags().StringVar(&ssoSessionName, "sso-session", "", "Optional name of the SSO session given at login. Defaults to the only sso-session in the AWS config.")
+	_ = credentialsCmd.MarkFlagRequired("account")
+	_ = credentialsCmd.MarkFlagRequired("role")
+}
+
+// processCredentials is the output of a credential_process.
+type processCredentials struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+func printProcessCredentials() {
+	creds, err := cachedRoleCredentials(ssoAccountID, ssoRoleName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		log.Fatalf("unable to format credentials, %v", err)
+	}
+	fmt.Println(string(out))
+}
+
+// cachedRoleCredentials returns the cached credentials of the role, or fetches new ones if they are missing or
+// about to expire.
+func cachedRoleCredentials(accountID string, roleName string) (processCredentials, error) {
+	session, err := resolveSSOSession()
+	if err != nil {
+		return processCredentials{}, err
+	}
+
+	cachePath, err := roleCredentialsCachePath(session, accountID, roleName)
+	if err != nil {
+		return processCredentials{}, err
+	}
+
+	var creds processCredentials
+	if b, err := os.ReadFile(cachePath); err == nil && json.Unmarshal(b, &creds) == nil {
+		if time.Until(creds.Expiration) > roleCredentialsRefreshWindow {
+			return creds, nil
+		}
+	}
+
+	creds, err = fetchRoleCredentials(session, accountID, roleName)
+	if err != nil {
+		return processCredentials{}, err
+	}
+
+	// failing to cache only costs another request next time
+	if b, err := json.Marshal(creds); err == nil {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err == nil {
+			os.WriteFile(cachePath, b, 0600)
+		}
+	}
+	return creds, nil
+}
+
+// resolveSSOSession returns the name of the SSO session given by --sso-session, or the only one in the AWS config.
+func resolveSSOSession() (string, error) {
+	if ssoSessionName != "" {
+		return ssoSessionName, nil
+	}
+
+	names, err := awsConfigSessionNames()
+	if err != nil {
+		return "", fmt.Errorf("unable to read AWS config, %w", err)
+	}
+	switch len(names) {
+	case 0:
+		return "", errors.New("no sso-session found in the AWS config, please run \"terra3 login\" or provide --sso-session")
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("%d sso-sessions found in the AWS config (%s), please select one with --sso-session", len(names), strings.Join(names, ", "))
+	}
+}
+
+// fetchRoleCredentials gets credentials of the role with the cached access token of the session, refreshing the
+// token first if it has expired.
+func fetchRoleCredentials(session string, accountID string, roleName string) (processCredentials, error) {
+	token, tokenPath, err := readSSOTokenCache(session)
+	if os.IsNotExist(err) {
+		return processCredentials{}, fmt.Errorf("no cached token found for SSO session %s, please run \"terra3 login\"", session)
+	}
+	if err != nil {
+		return processCredentials{}, err
+	}
+
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		return processCredentials{}, fmt.Errorf("unable to load SDK config, %w", err)
+	}
+	// the SSO APIs are called with the access token instead of credentials, which also avoids a loop if the
+	// profile uses this command as credential_process. They are called in the region of the login, unless
+	// --region is given on the command line; the region of the project environment is not applied to this command.
+	cfg = cfg.Copy()
+	cfg.Credentials = aws.AnonymousCredentials{}
+	if region == "" {
+		cfg.Region = token.Region
+	}
+
+	// refreshes the token with the refresh token of the login and updates the cache, if necessary
+	tokenProvider := ssocreds.NewSSOTokenProvider(ssooidc.NewFromConfig(cfg), tokenPath)
+	accessToken, err := tokenProvider.RetrieveBearerToken(context.TODO())
+	if err != nil {
+		return processCredentials{}, fmt.Errorf("the SSO session %s has expired, please run \"terra3 login\": %w", session, err)
+	}
+
+	out, err := sso.NewFromConfig(cfg).GetRoleCredentials(context.TODO(), &sso.GetRoleCredentialsInput{
+		AccessToken: aws.String(accessToken.Value),
+		AccountId:   aws.String(accountID),
+		RoleName:    aws.String(roleName),
+	})
+	if err != nil {
+		return processCredentials{}, credentialsError(err)
+	}
+
+	return processCredentials{
+		Version:         1,
+		AccessKeyId:     aws.ToString(out.RoleCredentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(out.RoleCredentials.SecretAccessKey),
+		SessionToken:    aws.ToString(out.RoleCredentials.SessionToken),
+		Expiration:      time.UnixMilli(out.RoleCredentials.Expiration).UTC(),
+	}, nil
+}
+
+// roleCredentialsCachePath returns the cache file of the role credentials in the user's cache directory.
+func roleCredentialsCachePath(session string, accountID string, roleName string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	hash := sha1.Sum([]byte(session + "|" + accountID + "|" + roleName))
+	return filepath.Join(dir, "terra3", "credentials", hex.EncodeToString(hash[:])+".json"), nil
+}
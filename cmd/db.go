@@ -1,54 +1,57 @@
 package cmd
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"github.com/it-objects/terra3-cli/ssmclient"
 	"log"
+	"net"
+	"net/url"
 	"strconv"
 	"strings"
-	"time"
 
 	"context"
 	"os"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/rds"
-	"github.com/aws/aws-sdk-go-v2/service/sso"
-	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 
 	"github.com/aws/smithy-go"
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
-
-	"github.com/pkg/browser"
 )
 
 func init() {
 	dbCmd.AddCommand(dbPortForwardCmd)
-	dbPortForwardCmd.Flags().StringVarP(&profile, "profile", "p", "", "Optional AWS profile to use. If not provided, a selection menu will open.")
-}
-
-var loginCmd = &cobra.Command{
-	Use:   "login",
-	Short: "Built-in OIDC login without requiring the AWS CLI. (experimental)",
-	Long:  `Built-in OIDC login without requiring the AWS CLI. This feature is experimental.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		login()
-	},
+	dbPortForwardCmd.Flags().StringVarP(&bastion, "bastion", "b", "", "Optional EC2 instance ID of the bastion host. If not provided, the bastion host is detected automatically.")
+	dbPortForwardCmd.Flags().StringVar(&bastionTag, "bastion-tag", "", "Optional tag of the bastion host as key=value, e.g. Name=staging-bastion. Used if --bastion is not given.")
+	dbPortForwardCmd.Flags().StringVar(&bastionTarget, "target", "", "Optional bastion host as instance ID, tag_key:tag_value, IP address or DNS name.")
+	dbPortForwardCmd.Flags().StringVarP(&dbIdentifier, "db-identifier", "d", "", "Optional identifier of the RDS instance, Aurora cluster or RDS Proxy endpoint to forward to. If not provided and several databases exist, a selection menu will open.")
+	dbPortForwardCmd.Flags().StringVarP(&dbEndpoint, "endpoint", "e", "", "Optional Aurora cluster endpoint to forward to: writer, reader or the name of a custom endpoint. Defaults to writer.")
+	dbPortForwardCmd.Flags().IntVarP(&localPort, "local-port", "l", 0, "Optional local port to listen on. Use 0 to pick a free port. If not provided, you will be asked for it.")
+	dbPortForwardCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Accept proposed defaults instead of prompting for missing values.")
+	dbPortForwardCmd.Flags().BoolVar(&detach, "detach", false, "Run the port-forward in the background. Use \"terra3 tunnel\" to list and stop it.")
+	dbPortForwardCmd.Flags().BoolVar(&reconnect, "reconnect", false, "Start a new session on the same local port if the session is closed.")
+	dbPortForwardCmd.Flags().DurationVar(&keepalive, "keepalive", 0, "Optional interval in which keepalive frames are sent through the session to prevent the idle timeout, e.g. 5m.")
+	dbPortForwardCmd.Flags().BoolVar(&iamAuth, "iam-auth", false, "Generate an IAM database authentication token and print a connection string for the local port.")
+	dbPortForwardCmd.Flags().BoolVar(&withCredentials, "with-credentials", false, "Fetch the database credentials from Secrets Manager and print a connection string for the local port.")
+	dbPortForwardCmd.Flags().StringVarP(&dbUser, "db-user", "u", "", "Optional database user for --iam-auth. Defaults to the master user of the database.")
 }
 
 var dbCmd = &cobra.Command{
 	Use:   "db",
 	Short: "Interact with your provisioned AWS RDS instance.",
-	Long: `Interact with your provisioned AWS RDS instance. Use one of the sub-commands.
-	* port-forward: Establish a port forwarding to your RDS instance.
+	Long: `Interact with your provisioned AWS RDS instance or Aurora cluster. Use one of the sub-commands.
+	* port-forward: Establish a port forwarding to your RDS instance or Aurora cluster.
+	* token: Generate an IAM database authentication token.
+	* credentials: Print the database credentials stored in Secrets Manager.
+	* connect: Connect to your database with its native client through a port-forward.
 	`,
 }
 
@@ -57,8 +60,30 @@ var dbPortForwardCmd = &cobra.Command{
 	Short: "Create a secure port-forward to the private RDS database using SSM.",
 	Long: `Create a secure port-forward to the private RDS database using SSM. If used without the profile parameter,
 	it will open up a selection menu to choose the AWS profile to use. If used with a profile parameter, it will use 
-	the given profile.`,
+	the given profile.
+
+	Every value that would otherwise be asked for can be given as a flag, which makes the command usable in
+	scripts and CI jobs. Prompts are only shown if a value is missing and a terminal is attached. Use --yes
+	to accept the proposed defaults (default credentials, detected bastion host, local port equal to the
+	database port) without being asked.
+
+	For Aurora clusters, the port-forward goes to the cluster writer endpoint, so it keeps working after a
+	failover. Use --endpoint to select the reader endpoint or a custom endpoint instead. RDS Proxy endpoints
+	are listed alongside instances and clusters and can be selected the same way.
+
+	SSM closes sessions after a period of inactivity or if the connection drops. Use --reconnect to start a new
+	session on the same local port in that case. Use --keepalive to send keepalive frames through the session
+	regularly, so that the idle timeout never fires. They are dropped by the SSM agent and do not reach the
+	database, but require an agent version newer than 3.0.196.0 and a session without KMS encryption; a warning
+	is printed otherwise.
+
+	The bastion host is given with --bastion as instance ID, or with --target as instance ID, tag_key:tag_value,
+	IP address or DNS name. Without either, the instance tagged with --bastion-tag is used, and otherwise a
+	running instance with "bastion" in one of its tags.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if !cmd.Flags().Changed("local-port") {
+			localPort = -1
+		}
 		dbPortForwardToDB()
 	},
 }
@@ -66,72 +91,305 @@ var dbPortForwardCmd = &cobra.Command{
 func dbPortForwardToDB() {
 	fmt.Print("Terra3 CLI: Establish a secure port-forward to the private RDS database using SSM with the profile you are going to pick.\nNote: if session is unused, it will close automatically after 60 seconds.\n")
 
-	// If profile is given by dbPortForwardCmd.Flags() then set os.Setenv("AWS_PROFILE", result)
+	selectProfile()
+
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	ec2Client := ec2.NewFromConfig(cfg)
+	rdsClient := rds.NewFromConfig(cfg)
+
+	bastionHostID := detectBastionHost(ec2Client)
+
+	db, err := getDBTarget(rdsClient, dbIdentifier, dbEndpoint)
+	if err != nil {
+		log.Fatalf("unable to get RDS URL, %v.", err)
+	}
+	rdsURL, rdsPort := db.Address, db.Port
+
+	showIamDetails()
+
+	fmt.Printf("\nBastion host detected with id:  %s\n", bastionHostID)
+	fmt.Printf("RDS database detected with url: %s:%d\n\n", rdsURL, rdsPort)
+
+	if localPort < 0 {
+		switch {
+		case assumeYes:
+			localPort = int(rdsPort)
+		case isInteractive():
+			proposedPort, err := ssmclient.NextFreeLocalPort(int(rdsPort))
+			if err != nil {
+				proposedPort = int(rdsPort)
+			}
+			wordPromptContent := promptContent{
+				"Please provide a port number.",
+				"What port number would you like to be opened locally?",
+			}
+			inputLocalPort := promptGetInput(wordPromptContent, int32(proposedPort))
+
+			// Convert inputLocalPort from string to int
+			localPort, err = strconv.Atoi(inputLocalPort)
+			if err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatal(errNotInteractive("--local-port"))
+		}
+	}
+
+	localPort, err = resolveLocalPort(localPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// machine-readable line for scripts picking up the port
+	fmt.Printf("LOCAL_PORT=%d\n", localPort)
+
+	if iamAuth {
+		printIAMAuthConnection(cfg, db, localPort)
+	} else if withCredentials {
+		printCredentialsConnection(cfg, db, localPort)
+	}
+
+	if detach {
+		detachTunnel(cfg, bastionHostID, db, localPort)
+		return
+	}
+
+	if reconnect {
+		superviseTunnel(cfg, bastionHostID, rdsURL, rdsPort, localPort)
+		return
+	}
+
+	// create ssm tunnel with internal ssh
+	ssm_tunnel(bastionHostID, rdsURL, rdsPort, localPort)
+}
+
+// selectProfile sets AWS_PROFILE to the profile given by the --profile flag. If no profile is given, a
+// selection menu is shown as long as prompting is possible and no credentials are set in the environment.
+// With --profile-filter, the menu only shows the matching profiles, and a single match is used right away.
+// Otherwise the default credential chain is used.
+func selectProfile() {
+	// If profile is given by the --profile flag then set os.Setenv("AWS_PROFILE", result)
 	if profile != "" {
 		os.Setenv("AWS_PROFILE", profile)
-	} else {
+	} else if profileFilter != "" || (canPrompt() && os.Getenv("AWS_ACCESS_KEY_ID") == "") {
 		// Load all AWS profiles
-		profiles, err := loadAllAWSProfiles()
+		profiles, err := loadAWSProfiles()
 		if err != nil {
 			log.Fatalf("unable to load AWS profiles, %v", err)
 		}
 
-		// Prompt user to select a profile
-		prompt := promptui.Select{
-			Label:     "Select AWS profile",
-			Items:     profiles,
-			CursorPos: 1,
+		if profileFilter != "" {
+			var matching []awsProfile
+			for _, p := range profiles {
+				if p.Matches(profileFilter) {
+					matching = append(matching, p)
+				}
+			}
+			if len(matching) == 0 {
+				log.Fatalf("no AWS profile matches %q", profileFilter)
+			}
+			profiles = matching
+		} else if len(profiles) == 0 {
+			// nothing to choose from, the default credential chain may still find credentials
+			return
+		}
+
+		items := make([]string, len(profiles))
+		for i, p := range profiles {
+			items[i] = fmt.Sprintf("%-30s | %s", p.Name, p.Details())
 		}
 
-		_, result, err := prompt.Run()
+		// Prompt user to select a profile
+		idx, err := selectOne("Select AWS profile", items, "--profile")
 		if err != nil {
-			log.Fatalf("prompt failed %v", err)
+			log.Fatalf("unable to select AWS profile, %v", err)
 		}
 
 		// Set the selected profile as the default profile
-		os.Setenv("AWS_PROFILE", result)
+		os.Setenv("AWS_PROFILE", profiles[idx].Name)
 	}
+}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+// resolveLocalPort checks whether the local port is free. Port 0 is replaced by an ephemeral port. If the port is
+// in use, the next free port is offered instead, or used right away with --yes.
+func resolveLocalPort(port int) (int, error) {
+	if port == 0 {
+		return ssmclient.FreeLocalPort()
 	}
 
-	ec2Client := ec2.NewFromConfig(cfg)
-	rdsClient := rds.NewFromConfig(cfg)
+	if ssmclient.LocalPortAvailable(port) {
+		return port, nil
+	}
 
-	bastionHostID, err := getBastionHostID(ec2Client)
+	next, err := ssmclient.NextFreeLocalPort(port + 1)
 	if err != nil {
-		bastionHostID, err = selectRunningEC2Instance(ec2Client)
-		if err != nil {
-			log.Printf("unable to get any running EC2 instance. Please launch a bastion host first and try again.")
+		return 0, fmt.Errorf("local port %d is already in use and %v", port, err)
+	}
+
+	switch {
+	case assumeYes:
+		log.Printf("local port %d is already in use, using port %d instead", port, next)
+		return next, nil
+	case isInteractive():
+		prompt := promptui.Prompt{
+			Label:     fmt.Sprintf("Local port %d is already in use. Use port %d instead", port, next),
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			return 0, fmt.Errorf("local port %d is already in use", port)
 		}
+		return next, nil
 	}
+	return 0, fmt.Errorf("local port %d is already in use, please provide another one with --local-port or use --local-port 0 to pick a free one", port)
+}
 
-	rdsURL, rdsPort, err := getRDSURL(rdsClient)
+// isInteractive reports whether stdin is attached to a terminal, i.e. whether it is safe to show a prompt.
+func isInteractive() bool {
+	fi, err := os.Stdin.Stat()
 	if err != nil {
-		log.Fatalf("unable to get RDS URL, %v.", err)
+		return false
 	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
 
-	showIamDetails()
+// canPrompt reports whether missing values may be asked for. This is not the case if --yes is given or
+// if no terminal is attached.
+func canPrompt() bool {
+	return !assumeYes && isInteractive()
+}
 
-	fmt.Printf("\nBastion host detected with id:  %s\n", bastionHostID)
-	fmt.Printf("RDS database detected with url: %s:%d\n\n", rdsURL, rdsPort)
+// errNotInteractive is returned if a value is missing which would require a prompt, but no terminal is attached.
+func errNotInteractive(flag string) error {
+	return fmt.Errorf("stdin is not a terminal, please provide %s or use --yes to accept the proposed default", flag)
+}
+
+// sharedAWSConfig is the SDK configuration shared by all commands, so that every call uses the same profile,
+// region and credentials cache.
+var sharedAWSConfig *aws.Config
 
-	wordPromptContent := promptContent{
-		"Please provide a port number.",
-		"What port number would you like to be opened locally?",
+// loadAWSConfig loads the SDK configuration for the selected profile, taking the --region flag into account. It is
+// loaded once; later calls return the same configuration.
+func loadAWSConfig() (aws.Config, error) {
+	if sharedAWSConfig != nil {
+		return *sharedAWSConfig, nil
 	}
-	inputLocalPort := promptGetInput(wordPromptContent, rdsPort)
 
-	// Convert inputLocalPort from string to int
-	localPort, err := strconv.Atoi(inputLocalPort)
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
 	if err != nil {
-		log.Fatal(err)
+		return cfg, err
 	}
+	sharedAWSConfig = &cfg
+	return cfg, nil
+}
 
-	// create ssm tunnel with internal ssh
-	ssm_tunnel(bastionHostID, rdsURL, rdsPort, localPort)
+// useAWSCredentials makes the following commands use the credentials instead of a profile, replacing the
+// configuration loaded so far. They are set in the environment as well, so that no profile is asked for and child
+// processes, e.g. of supervised port-forwards, pick them up.
+func useAWSCredentials(creds aws.Credentials) error {
+	os.Unsetenv("AWS_PROFILE")
+	os.Setenv("AWS_ACCESS_KEY_ID", creds.AccessKeyID)
+	os.Setenv("AWS_SECRET_ACCESS_KEY", creds.SecretAccessKey)
+	os.Setenv("AWS_SESSION_TOKEN", creds.SessionToken)
+	profile = ""
+
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	opts = append(opts, config.WithCredentialsProvider(credentials.StaticCredentialsProvider{Value: creds}))
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return err
+	}
+	sharedAWSConfig = &cfg
+	return nil
+}
+
+// detectBastionHost returns the instance ID given by --bastion, the instance resolved from --target or the running
+// instance with the tag given by --bastion-tag. Otherwise the bastion host is detected, falling back to a selection
+// menu of all running EC2 instances.
+func detectBastionHost(client *ec2.Client) string {
+	if bastion != "" {
+		return bastion
+	}
+
+	if bastionTarget != "" {
+		cfg, err := loadAWSConfig()
+		if err != nil {
+			log.Fatalf("unable to load SDK config, %v", err)
+		}
+		bastionHostID, err := ssmclient.ResolveTarget(bastionTarget, cfg)
+		if err != nil {
+			log.Fatalf("unable to resolve target %s, %v. Please use an instance ID, tag_key:tag_value, an IP address or a DNS name resolving to the instance.", bastionTarget, err)
+		}
+		return bastionHostID
+	}
+
+	if bastionTag != "" {
+		bastionHostID, err := findBastionHostByTag(client, bastionTag)
+		if err != nil {
+			log.Fatalf("unable to find bastion host tagged %s, %v", bastionTag, err)
+		}
+		return bastionHostID
+	}
+
+	bastionHostID, err := getBastionHostID(client)
+	if err != nil {
+		if !canPrompt() {
+			log.Fatalf("unable to detect a bastion host, %v. Please provide one with --bastion.", err)
+		}
+		bastionHostID, err = selectRunningEC2Instance(client)
+		if err != nil {
+			log.Fatalf("unable to get any running EC2 instance. Please launch a bastion host first and try again.")
+		}
+	}
+	return bastionHostID
+}
+
+// findBastionHostByTag returns the running instance with the tag, given as key=value. If several instances carry
+// the tag, one of them is selected.
+func findBastionHostByTag(client *ec2.Client, tag string) (string, error) {
+	key, value, err := parseTag("--bastion-tag", tag)
+	if err != nil {
+		return "", err
+	}
+
+	var ids []string
+	var items []string
+	paginator := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("tag:" + key), Values: []string{value}},
+			{Name: aws.String("instance-state-name"), Values: []string{"running"}},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return "", err
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				ids = append(ids, aws.ToString(instance.InstanceId))
+				items = append(items, fmt.Sprintf("%-20s | %s", aws.ToString(instance.InstanceId), aws.ToTime(instance.LaunchTime).Local().Format("2006-01-02 15:04:05")))
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		return "", errors.New("no running instance found")
+	}
+	idx, err := selectOne("Select bastion host", items, "--bastion")
+	if err != nil {
+		return "", err
+	}
+	return ids[idx], nil
 }
 
 func selectRunningEC2Instance(client *ec2.Client) (string, error) {
@@ -204,6 +462,8 @@ func selectRunningEC2Instance(client *ec2.Client) (string, error) {
 	return instances[idx].ID, nil
 }
 
+// getBastionHostID is the fallback detection of the bastion host: the first running instance with "bastion" in
+// the value of one of its tags.
 func getBastionHostID(client *ec2.Client) (string, error) {
 	resp, err := client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{})
 	if err != nil {
@@ -226,160 +486,390 @@ func getBastionHostID(client *ec2.Client) (string, error) {
 	return "", fmt.Errorf("no bastion host found")
 }
 
-func getRDSURL(client *rds.Client) (string, int32, error) {
-	resp, err := client.DescribeDBInstances(context.TODO(), &rds.DescribeDBInstancesInput{})
-	if err != nil {
-		return "", -1, err
-	}
+// dbTarget describes a database endpoint which can be used as the remote end of a port-forward.
+type dbTarget struct {
+	Kind       string
+	Identifier string
+	Engine     string
+	Status     string
+	Address    string
+	Port       int32
+	DBName     string
+	// MasterUsername is the default user for connections to the database. It is empty for proxies.
+	MasterUsername string
+	// IAMAuth is true if IAM database authentication is enabled.
+	IAMAuth bool
+	// SecretArn references the Secrets Manager secret holding the credentials of the database.
+	SecretArn string
+	// Endpoints holds the addresses of an Aurora cluster by name ("writer", "reader" and custom endpoints).
+	Endpoints map[string]string
+}
+
+const (
+	dbKindInstance = "instance"
+	dbKindCluster  = "cluster"
+	dbKindProxy    = "proxy"
+)
+
+// proxyPorts maps the engine family of an RDS Proxy to the port it is listening on.
+var proxyPorts = map[string]int32{
+	"MYSQL":      3306,
+	"POSTGRESQL": 5432,
+	"SQLSERVER":  1433,
+}
+
+// usable reports whether a port-forward to the database can be established.
+func (t dbTarget) usable() bool {
+	return t.Status == "available" && t.Address != ""
+}
 
-	if len(resp.DBInstances) == 0 {
-		return "", -1, fmt.Errorf("no RDS instances found")
+func (t dbTarget) String() string {
+	info := fmt.Sprintf("%-8s | %-30s | %-18s | %-12s | %s:%d", t.Kind, t.Identifier, t.Engine, t.Status, t.Address, t.Port)
+	if !t.usable() {
+		info += " (unusable)"
 	}
+	return info
+}
 
-	return *resp.DBInstances[0].Endpoint.Address, *resp.DBInstances[0].Endpoint.Port, nil
+// engineFamily returns the database family of the engine, i.e. postgres, mysql, sqlserver or oracle. Aurora,
+// MariaDB and proxy engines are mapped to the family they are compatible with.
+func (t dbTarget) engineFamily() string {
+	switch {
+	case strings.Contains(t.Engine, "postgres"):
+		return "postgres"
+	case strings.Contains(t.Engine, "mysql"), strings.Contains(t.Engine, "mariadb"):
+		return "mysql"
+	case strings.HasPrefix(t.Engine, "sqlserver"):
+		return "sqlserver"
+	case strings.HasPrefix(t.Engine, "oracle"):
+		return "oracle"
+	}
+	return ""
 }
 
-// add array of constants containing all AWS regions available
-var awsRegions = []string{
-	"us-east-1",
-	"us-east-2",
-	"us-west-1",
-	"us-west-2",
-	"ap-east-1",
-	"ap-south-1",
-	"ap-northeast-1",
-	"ap-northeast-2",
-	"ap-northeast-3",
-	"ap-southeast-1",
-	"ap-southeast-2",
-	"ca-central-1",
-	"cn-north-1",
-	"cn-northwest-1",
-	"eu-central-1",
-	"eu-central-2",
-	"eu-west-1",
-	"eu-west-2",
-	"eu-west-3",
-	"eu-north-1",
-	"il-central-1",
-	"me-south-1",
-	"sa-east-1",
-	"me-central-1",
-	"us-gov-east-1",
-	"us-gov-west-1",
+// connectionURI returns a connection string for the database reachable through a port-forward on localPort.
+func (t dbTarget) connectionURI(user string, password string, localPort int) string {
+	u := url.URL{
+		Scheme: t.engineFamily(),
+		User:   url.UserPassword(user, password),
+		Host:   net.JoinHostPort("localhost", strconv.Itoa(localPort)),
+	}
+
+	switch t.engineFamily() {
+	case "postgres":
+		u.Scheme = "postgresql"
+		dbName := t.DBName
+		if dbName == "" {
+			dbName = "postgres"
+		}
+		u.Path = "/" + dbName
+		// the certificate cannot be verified against localhost, but traffic is encrypted nevertheless
+		u.RawQuery = "sslmode=require"
+	case "mysql":
+		u.Host = net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort))
+		u.Path = "/" + t.DBName
+		u.RawQuery = "ssl-mode=REQUIRED"
+	case "sqlserver":
+		if t.DBName != "" {
+			u.RawQuery = url.Values{"database": {t.DBName}}.Encode()
+		}
+	default:
+		u.Path = "/" + t.DBName
+	}
+
+	return u.String()
 }
 
-func login() {
+// withEndpoint returns the target pointing at the named endpoint of an Aurora cluster, which is either
+// "writer", "reader" or the name of a custom endpoint. An empty name selects the writer endpoint.
+func (t dbTarget) withEndpoint(name string) (dbTarget, error) {
+	if t.Kind != dbKindCluster {
+		if name != "" {
+			return dbTarget{}, fmt.Errorf("database %s is not an Aurora cluster, endpoint %s cannot be used", t.Identifier, name)
+		}
+		return t, nil
+	}
 
-	// prompt user for a url
-	// Prompt user to select a profile
-	prompt1 := promptui.Prompt{
-		Label:   "SSO URL",
-		Default: "",
+	if name == "" {
+		name = "writer"
 	}
 
-	resultSSOUrl, err := prompt1.Run()
+	address, ok := t.Endpoints[name]
+	if !ok {
+		return dbTarget{}, fmt.Errorf("cluster %s has no endpoint named %s", t.Identifier, name)
+	}
+	t.Address = address
+	return t, nil
+}
 
+// getDBTarget returns the database to forward to. If identifier is set, the database with that identifier is
+// used. Otherwise, if more than one database is found, a selection menu is shown. For Aurora clusters, the
+// given endpoint is selected.
+func getDBTarget(client *rds.Client, identifier string, endpoint string) (dbTarget, error) {
+	t, err := findDBTarget(client, identifier)
 	if err != nil {
-		fmt.Printf("Prompt failed %v\n", err)
-		return
+		return dbTarget{}, err
 	}
+	return t.withEndpoint(endpoint)
+}
 
-	// Prompt user to select a profile
-	prompt := promptui.Select{
-		Label:     "Please provide an AWS region.",
-		Items:     awsRegions,
-		CursorPos: 14,
-	}
-	_, resultRegion, err := prompt.Run()
+func findDBTarget(client *rds.Client, identifier string) (dbTarget, error) {
+	targets, err := listDBTargets(client)
 	if err != nil {
-		log.Fatalf("prompt failed %v", err)
+		return dbTarget{}, err
 	}
 
-	var (
-		startURL string = "https://" + resultSSOUrl + ".awsapps.com/start"
-		region          = resultRegion
-	)
+	if identifier != "" {
+		for _, t := range targets {
+			if t.Identifier == identifier {
+				if !t.usable() {
+					return dbTarget{}, fmt.Errorf("database %s is not available (status: %s)", t.Identifier, t.Status)
+				}
+				return t, nil
+			}
+		}
+		return dbTarget{}, fmt.Errorf("no database with identifier %s found", identifier)
+	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithDefaultRegion(region))
-	if err != nil {
-		log.Fatalf("%v", err)
+	if len(targets) == 0 {
+		return dbTarget{}, fmt.Errorf("no RDS instances, Aurora clusters or RDS proxies found")
 	}
-	// create SSO oidcClient client to trigger login flow
-	oidcClient := ssooidc.NewFromConfig(cfg)
 
-	// register your client which is triggering the login flow
-	register, err := oidcClient.RegisterClient(context.TODO(), &ssooidc.RegisterClientInput{
-		ClientName: aws.String("terra3-cli-client"),
-		ClientType: aws.String("public"),
-	})
+	if len(targets) == 1 {
+		if !targets[0].usable() {
+			return dbTarget{}, fmt.Errorf("database %s is not available (status: %s)", targets[0].Identifier, targets[0].Status)
+		}
+		return targets[0], nil
+	}
 
-	if err != nil {
-		log.Fatal(err)
+	if !canPrompt() {
+		return dbTarget{}, fmt.Errorf("%d databases found, please select one with --db-identifier", len(targets))
 	}
 
-	// authorize your device using the client registration response
-	deviceAuth, err := oidcClient.StartDeviceAuthorization(context.TODO(), &ssooidc.StartDeviceAuthorizationInput{
-		ClientId:     register.ClientId,
-		ClientSecret: register.ClientSecret,
-		StartUrl:     aws.String(startURL),
-	})
+	return selectDBTarget(targets)
+}
 
+// listDBTargets returns all RDS Proxy endpoints, Aurora clusters and RDS instances of the account.
+func listDBTargets(client *rds.Client) ([]dbTarget, error) {
+	proxies, err := listDBProxies(client)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	url := aws.ToString(deviceAuth.VerificationUriComplete)
-	log.Printf("If your browser is not opened automatically, please open link:\n%v\n", url)
-	err = browser.OpenURL(url)
+	clusters, err := listDBClusters(client)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	instances, err := listDBInstances(client)
+	if err != nil {
+		return nil, err
 	}
 
-	var token *ssooidc.CreateTokenOutput
-	approved := false
+	targets := append(proxies, clusters...)
+	return append(targets, instances...), nil
+}
+
+// listDBProxies returns the default endpoint of every RDS Proxy as well as all additional proxy endpoints.
+func listDBProxies(client *rds.Client) ([]dbTarget, error) {
+	var targets []dbTarget
+	engineFamilies := make(map[string]string)
+	iamAuth := make(map[string]bool)
+	secretArns := make(map[string]string)
 
-	// poll the client until it has finished authorization.
-	for !approved {
-		t, err := oidcClient.CreateToken(context.TODO(), &ssooidc.CreateTokenInput{
-			ClientId:     register.ClientId,
-			ClientSecret: register.ClientSecret,
-			DeviceCode:   deviceAuth.DeviceCode,
-			GrantType:    aws.String("urn:ietf:params:oauth:grant-type:device_code"),
-		})
+	paginator := rds.NewDescribeDBProxiesPaginator(client, &rds.DescribeDBProxiesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
 		if err != nil {
-			isPending := strings.Contains(err.Error(), "AuthorizationPendingException:")
-			log.Println("Authorization pending...")
-			if isPending {
-				log.Print(".")
-				time.Sleep(time.Duration(deviceAuth.Interval) * time.Second)
+			return nil, err
+		}
+
+		for _, proxy := range page.DBProxies {
+			family := aws.ToString(proxy.EngineFamily)
+			engineFamilies[aws.ToString(proxy.DBProxyName)] = family
+			for _, auth := range proxy.Auth {
+				if auth.IAMAuth != "" && auth.IAMAuth != "DISABLED" {
+					iamAuth[aws.ToString(proxy.DBProxyName)] = true
+				}
+				if secretArns[aws.ToString(proxy.DBProxyName)] == "" {
+					secretArns[aws.ToString(proxy.DBProxyName)] = aws.ToString(auth.SecretArn)
+				}
+			}
+			targets = append(targets, dbTarget{
+				Kind:       dbKindProxy,
+				Identifier: aws.ToString(proxy.DBProxyName),
+				Engine:     strings.ToLower(family),
+				Status:     string(proxy.Status),
+				Address:    aws.ToString(proxy.Endpoint),
+				Port:       proxyPorts[family],
+				IAMAuth:    iamAuth[aws.ToString(proxy.DBProxyName)],
+				SecretArn:  secretArns[aws.ToString(proxy.DBProxyName)],
+			})
+		}
+	}
+
+	endpointPaginator := rds.NewDescribeDBProxyEndpointsPaginator(client, &rds.DescribeDBProxyEndpointsInput{})
+	for endpointPaginator.HasMorePages() {
+		page, err := endpointPaginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, endpoint := range page.DBProxyEndpoints {
+			// the default endpoint has already been added with the proxy itself
+			if aws.ToBool(endpoint.IsDefault) {
 				continue
 			}
+			family := engineFamilies[aws.ToString(endpoint.DBProxyName)]
+			targets = append(targets, dbTarget{
+				Kind:       dbKindProxy,
+				Identifier: aws.ToString(endpoint.DBProxyEndpointName),
+				Engine:     strings.ToLower(family),
+				Status:     string(endpoint.Status),
+				Address:    aws.ToString(endpoint.Endpoint),
+				Port:       proxyPorts[family],
+				IAMAuth:    iamAuth[aws.ToString(endpoint.DBProxyName)],
+				SecretArn:  secretArns[aws.ToString(endpoint.DBProxyName)],
+			})
 		}
-		approved = true
-		token = t
 	}
 
-	ssoClient := sso.NewFromConfig(cfg)
+	return targets, nil
+}
 
-	log.Println("Fetching list of accounts for this user")
-	accountPaginator := sso.NewListAccountsPaginator(ssoClient, &sso.ListAccountsInput{
-		AccessToken: token.AccessToken,
-	})
+func listDBClusters(client *rds.Client) ([]dbTarget, error) {
+	customEndpoints := make(map[string]map[string]string)
+
+	endpointPaginator := rds.NewDescribeDBClusterEndpointsPaginator(client, &rds.DescribeDBClusterEndpointsInput{})
+	for endpointPaginator.HasMorePages() {
+		page, err := endpointPaginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, endpoint := range page.DBClusterEndpoints {
+			if aws.ToString(endpoint.EndpointType) != "CUSTOM" {
+				continue
+			}
+			clusterID := aws.ToString(endpoint.DBClusterIdentifier)
+			if customEndpoints[clusterID] == nil {
+				customEndpoints[clusterID] = make(map[string]string)
+			}
+			customEndpoints[clusterID][aws.ToString(endpoint.DBClusterEndpointIdentifier)] = aws.ToString(endpoint.Endpoint)
+		}
+	}
+
+	var targets []dbTarget
+
+	paginator := rds.NewDescribeDBClustersPaginator(client, &rds.DescribeDBClustersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cluster := range page.DBClusters {
+			t := dbTarget{
+				Kind:       dbKindCluster,
+				Identifier: aws.ToString(cluster.DBClusterIdentifier),
+				Engine:     aws.ToString(cluster.Engine),
+				Status:     aws.ToString(cluster.Status),
+				Address:    aws.ToString(cluster.Endpoint),
+				Port:       aws.ToInt32(cluster.Port),
+				DBName:     aws.ToString(cluster.DatabaseName),
+				Endpoints:  map[string]string{"writer": aws.ToString(cluster.Endpoint)},
+
+				MasterUsername: aws.ToString(cluster.MasterUsername),
+				IAMAuth:        aws.ToBool(cluster.IAMDatabaseAuthenticationEnabled),
+			}
+			if cluster.MasterUserSecret != nil {
+				t.SecretArn = aws.ToString(cluster.MasterUserSecret.SecretArn)
+			}
+			if cluster.ReaderEndpoint != nil {
+				t.Endpoints["reader"] = aws.ToString(cluster.ReaderEndpoint)
+			}
+			for name, address := range customEndpoints[t.Identifier] {
+				t.Endpoints[name] = address
+			}
+			targets = append(targets, t)
+		}
+	}
+
+	return targets, nil
+}
+
+func listDBInstances(client *rds.Client) ([]dbTarget, error) {
+	var targets []dbTarget
 
-	for accountPaginator.HasMorePages() {
-		x, err := accountPaginator.NextPage(context.TODO())
+	paginator := rds.NewDescribeDBInstancesPaginator(client, &rds.DescribeDBInstancesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		for _, y := range x.AccountList {
-			log.Println("-------------------------------------------------------")
-			log.Printf("Account ID: %v Name: %v Email: %v\n", aws.ToString(y.AccountId), aws.ToString(y.AccountName), aws.ToString(y.EmailAddress))
+
+		for _, instance := range page.DBInstances {
+			t := dbTarget{
+				Kind:       dbKindInstance,
+				Identifier: aws.ToString(instance.DBInstanceIdentifier),
+				Engine:     aws.ToString(instance.Engine),
+				Status:     aws.ToString(instance.DBInstanceStatus),
+				DBName:     aws.ToString(instance.DBName),
+
+				MasterUsername: aws.ToString(instance.MasterUsername),
+				IAMAuth:        aws.ToBool(instance.IAMDatabaseAuthenticationEnabled),
+			}
+			if instance.MasterUserSecret != nil {
+				t.SecretArn = aws.ToString(instance.MasterUserSecret.SecretArn)
+			}
+			// the endpoint is not set while an instance is being created
+			if instance.Endpoint != nil {
+				t.Address = aws.ToString(instance.Endpoint.Address)
+				t.Port = aws.ToInt32(instance.Endpoint.Port)
+			}
+			targets = append(targets, t)
 		}
 	}
+
+	return targets, nil
 }
 
-var profile string
+func selectDBTarget(targets []dbTarget) (dbTarget, error) {
+	prompt := promptui.Select{
+		Label: "Select RDS database",
+		Items: targets,
+	}
+
+	for {
+		idx, _, err := prompt.Run()
+		if err != nil {
+			return dbTarget{}, fmt.Errorf("prompt failed: %v", err)
+		}
+
+		if targets[idx].usable() {
+			return targets[idx], nil
+		}
+		fmt.Printf("Database %s is not available (status: %s), please select another one.\n", targets[idx].Identifier, targets[idx].Status)
+		prompt.CursorPos = idx
+	}
+}
+
+var (
+	profile         string
+	profileFilter   string
+	region          string
+	bastion         string
+	bastionTag      string
+	bastionTarget   string
+	dbIdentifier    string
+	dbEndpoint      string
+	localPort       int
+	assumeYes       bool
+	iamAuth         bool
+	withCredentials bool
+	dbUser          string
+	detach          bool
+)
 
 type promptContent struct {
 	errorMsg string
@@ -387,7 +877,7 @@ type promptContent struct {
 }
 
 func ssm_tunnel(bastionHostID string, rdsURL string, rdsPort int32, localPort int) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	cfg, err := loadAWSConfig()
 	if err != nil {
 		log.Fatalf("unable to load SDK config, %v", err)
 	}
@@ -397,11 +887,16 @@ func ssm_tunnel(bastionHostID string, rdsURL string, rdsPort int32, localPort in
 		RemotePort: int(rdsPort),
 		LocalPort:  localPort,
 		Host:       rdsURL,
+		KeepAlive:  keepalive,
 	}
 
-	// Alternatively, can be called as ssmclient.PortluginSession(cfg, tgt) to use the AWS-managed SSM session client code
-	//log.Fatal(ssmclient.PortForwardingSession(cfg, &in))
-	log.Fatal(ssmclient.PortPluginSession(cfg, &in))
+	// the native client falls back to ssmclient.PortPluginSession, the AWS-managed session client code, if the
+	// session requires KMS encryption
+	err = ssmclient.PortForwardingSession(cfg, &in)
+	exitOnInterrupt(err)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func promptGetInput(pc promptContent, proposedPort int32) string {
@@ -418,6 +913,9 @@ func promptGetInput(pc promptContent, proposedPort int32) string {
 		if port < 22 || port > 65535 {
 			return errors.New("invalid port number") // Fix: Changed error string to lowercase
 		}
+		if !ssmclient.LocalPortAvailable(port) {
+			return errors.New("port already in use")
+		}
 		return nil
 	}
 
@@ -447,15 +945,15 @@ func promptGetInput(pc promptContent, proposedPort int32) string {
 var DisableAccountAliasEnvVarName = "AWS_WHOAMI_DISABLE_ACCOUNT_ALIAS"
 
 type Whoami struct {
-	Account          string
-	AccountAliases   []string
-	Arn              string
-	Type             string
-	Name             string
-	RoleSessionName  *string
-	UserId           string
-	Region           string
-	SSOPermissionSet *string
+	Account          string   `json:"account"`
+	AccountAliases   []string `json:"accountAliases"`
+	Arn              string   `json:"arn"`
+	Type             string   `json:"type"`
+	Name             string   `json:"name"`
+	RoleSessionName  *string  `json:"roleSessionName,omitempty"`
+	UserId           string   `json:"userId"`
+	Region           string   `json:"region"`
+	SSOPermissionSet *string  `json:"ssoPermissionSet,omitempty"`
 }
 
 type WhoamiParams struct {
@@ -663,7 +1161,7 @@ func (whoami Whoami) Format() string {
 }
 
 func showIamDetails() {
-	awsConfig, err := config.LoadDefaultConfig(context.TODO())
+	awsConfig, err := loadAWSConfig()
 	if err != nil {
 		fmt.Print(err)
 		os.Exit(1)
@@ -673,52 +1171,8 @@ func showIamDetails() {
 	Whoami, err := NewWhoami(awsConfig, whoamiParams)
 
 	if err != nil {
-		fmt.Print(err)
-		os.Exit(1)
+		log.Fatal(credentialsError(err))
 	}
 
 	fmt.Println(Whoami.Format())
 }
-
-func loadAllAWSProfiles() ([]string, error) {
-	home := os.Getenv("HOME")
-	if home == "" {
-		// assume Windows
-		home = os.Getenv("USERPROFILE")
-	}
-	configFile := home + string(os.PathSeparator) + ".aws" + string(os.PathSeparator) + "config"
-	file, err := os.Open(configFile)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var profiles []string
-	scanner := bufio.NewScanner(file)
-	currentProfile := ""
-	shouldAddProfile := false
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "[profile ") {
-			if shouldAddProfile {
-				profiles = append(profiles, currentProfile)
-			}
-			currentProfile = line[9 : len(line)-1]
-			shouldAddProfile = false // Reset for the new profile
-		}
-
-		if true { // {strings.Contains(line, "sso_session = ito") {
-			shouldAddProfile = true
-		}
-	}
-
-	// Check the last profile processed by the loop
-	profiles = append(profiles, currentProfile)
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return profiles, nil
-}
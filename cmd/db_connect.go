@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/it-objects/terra3-cli/ssmclient"
+	"github.com/spf13/cobra"
+)
+
+// dbConnectCmd represents the db connect command
+var dbConnectCmd = &cobra.Command{
+	Use:   "connect",
+	Short: "Open a port-forward and connect to the RDS database with its native client.",
+	Long: `Open a port-forward to the RDS database on a free local port and start the native database client
+	through it: psql for PostgreSQL, mysql for MySQL and MariaDB and sqlcmd for SQL Server. Host, port, user
+	and database are prefilled. If the database credentials are stored in Secrets Manager, the password is
+	prefilled as well. With --iam-auth, an IAM database authentication token is used as password instead.
+	The port-forward is closed as soon as the client exits.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbConnect()
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbConnectCmd)
+	dbConnectCmd.Flags().StringVarP(&bastion, "bastion", "b", "", "Optional EC2 instance ID of the bastion host. If not provided, the bastion host is detected automatically.")
+	dbConnectCmd.Flags().StringVar(&bastionTag, "bastion-tag", "", "Optional tag of the bastion host as key=value, e.g. Name=staging-bastion. Used if --bastion is not given.")
+	dbConnectCmd.Flags().StringVar(&bastionTarget, "target", "", "Optional bastion host as instance ID, tag_key:tag_value, IP address or DNS name.")
+	dbConnectCmd.Flags().StringVarP(&dbIdentifier, "db-identifier", "d", "", "Optional identifier of the RDS instance, Aurora cluster or RDS Proxy endpoint. If not provided and several databases exist, a selection menu will open.")
+	dbConnectCmd.Flags().StringVarP(&dbEndpoint, "endpoint", "e", "", "Optional Aurora cluster endpoint: writer, reader or the name of a custom endpoint. Defaults to writer.")
+	dbConnectCmd.Flags().StringVarP(&dbUser, "db-user", "u", "", "Optional database user. Defaults to the user of the stored credentials or the master user.")
+	dbConnectCmd.Flags().BoolVar(&iamAuth, "iam-auth", false, "Use an IAM database authentication token as password.")
+	dbConnectCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Accept proposed defaults instead of prompting for missing values.")
+}
+
+func dbConnect() {
+	selectProfile()
+
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	bastionHostID := detectBastionHost(ec2.NewFromConfig(cfg))
+
+	db, err := getDBTarget(rds.NewFromConfig(cfg), dbIdentifier, dbEndpoint)
+	if err != nil {
+		log.Fatalf("unable to get RDS URL, %v.", err)
+	}
+
+	user, password := connectCredentials(cfg, db)
+
+	port, err := ssmclient.FreeLocalPort()
+	if err != nil {
+		log.Fatalf("unable to find a free local port, %v", err)
+	}
+
+	client, err := dbClientCommand(db, user, password, port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Opening port-forward to %s:%d via bastion host %s on local port %d...\n", db.Address, db.Port, bastionHostID, port)
+
+	// the port-forward gets its own process group, so pressing Ctrl-C in the client does not tear it down
+	output := &startupOutput{}
+	tunnel, err := newTunnelProcess(cfg, bastionHostID, db.Address, db.Port, port, false)
+	if err != nil {
+		log.Fatalf("unable to start port-forward, %v", err)
+	}
+	tunnel.cmd.Stdout = output
+	tunnel.cmd.Stderr = output
+	isolateProcess(tunnel.cmd)
+
+	if err := tunnel.start(); err != nil {
+		log.Fatalf("unable to start port-forward, %v", err)
+	}
+
+	if err := tunnel.waitForLocalPort(port, tunnelStartupTimeout); err != nil {
+		tunnel.stop()
+		fmt.Print(output.String())
+		log.Fatalf("port-forward did not come up, %v", err)
+	}
+	output.discard()
+
+	// Ctrl-C is meant for the client, e.g. to cancel a query, and must not end terra3 with the port-forward
+	// left behind. The port-forward is torn down once the client exits.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	err = client.Run()
+	tunnel.stop()
+	signal.Stop(interrupts)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		log.Fatalf("unable to run %s, %v", client.Path, err)
+	}
+}
+
+// connectCredentials returns the user and password to prefill. The password is empty if neither IAM
+// authentication is requested nor credentials are stored in Secrets Manager, leaving it to the client to ask.
+func connectCredentials(cfg aws.Config, db dbTarget) (user string, password string) {
+	if iamAuth {
+		token, user, err := buildIAMAuthToken(cfg, db)
+		if err != nil {
+			log.Fatalf("unable to generate IAM auth token, %v", err)
+		}
+		return user, token
+	}
+
+	user = db.MasterUsername
+	if db.SecretArn != "" {
+		creds, err := getDBCredentials(cfg, db)
+		if err != nil {
+			log.Printf("unable to get database credentials, %v. The client will ask for the password.", err)
+		} else if dbUser == "" || dbUser == creds.Username {
+			return creds.Username, creds.Password
+		}
+	}
+
+	if dbUser != "" {
+		user = dbUser
+	}
+	return user, ""
+}
+
+// dbClientCommand returns the command starting the native client of the database engine, connecting to the
+// port-forward on localPort.
+func dbClientCommand(db dbTarget, user string, password string, localPort int) (*exec.Cmd, error) {
+	var name string
+	var args, env []string
+
+	port := strconv.Itoa(localPort)
+
+	switch db.engineFamily() {
+	case "postgres":
+		name = "psql"
+		dbName := db.DBName
+		if dbName == "" {
+			dbName = "postgres"
+		}
+		args = []string{"-h", "localhost", "-p", port, "-U", user, "-d", dbName}
+		// the certificate cannot be verified against localhost, but traffic is encrypted nevertheless
+		env = []string{"PGSSLMODE=require"}
+		if password != "" {
+			env = append(env, "PGPASSWORD="+password)
+		}
+	case "mysql":
+		name = "mysql"
+		args = []string{"-h", "127.0.0.1", "-P", port, "-u", user, "--ssl-mode=REQUIRED"}
+		if iamAuth {
+			args = append(args, "--enable-cleartext-plugin")
+		}
+		if db.DBName != "" {
+			args = append(args, "-D", db.DBName)
+		}
+		if password != "" {
+			env = append(env, "MYSQL_PWD="+password)
+		}
+	case "sqlserver":
+		name = "sqlcmd"
+		args = []string{"-S", "tcp:localhost," + port, "-U", user}
+		if db.DBName != "" {
+			args = append(args, "-d", db.DBName)
+		}
+		if password != "" {
+			env = append(env, "SQLCMDPASSWORD="+password)
+		}
+	default:
+		return nil, fmt.Errorf("no native client known for database engine %s", db.Engine)
+	}
+
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found, please install it to connect to %s", name, db.Identifier)
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd, nil
+}
+
+// startupOutputLimit is the amount of output of the port-forward kept to explain why it did not come up.
+const startupOutputLimit = 64 * 1024
+
+// startupOutput keeps the first output of the port-forward until discard is called, so that it is shown if the
+// port-forward does not come up, without collecting the output of a long session.
+type startupOutput struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	discarded bool
+}
+
+func (o *startupOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.discarded && o.buf.Len() < startupOutputLimit {
+		o.buf.Write(p[:min(len(p), startupOutputLimit-o.buf.Len())])
+	}
+	return len(p), nil
+}
+
+func (o *startupOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// discard drops the output kept so far and all further output.
+func (o *startupOutput) discard() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.discarded = true
+	o.buf = bytes.Buffer{}
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStartupOutput(t *testing.T) {
+	var o startupOutput
+	chunk := bytes.Repeat([]byte("x"), startupOutputLimit/2+1)
+
+	for i := 0; i < 3; i++ {
+		if n, err := o.Write(chunk); n != len(chunk) || err != nil {
+			t.Fatalf("write = %d, %v, want %d, nil", n, err, len(chunk))
+		}
+	}
+	if got := len(o.String()); got != startupOutputLimit {
+		t.Errorf("kept %d bytes, want %d", got, startupOutputLimit)
+	}
+
+	// once the port-forward is up, its output is not collected anymore
+	o.discard()
+	if n, err := o.Write(chunk); n != len(chunk) || err != nil {
+		t.Fatalf("write = %d, %v, want %d, nil", n, err, len(chunk))
+	}
+	if got := o.String(); got != "" {
+		t.Errorf("kept %d bytes after discard", len(got))
+	}
+}
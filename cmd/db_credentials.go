@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/spf13/cobra"
+)
+
+// dbCredentialsCmd represents the db credentials command
+var dbCredentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "Print the credentials of the RDS database stored in Secrets Manager.",
+	Long: `Print the credentials of the RDS database stored in Secrets Manager. Terra3 lets RDS manage the master
+	user password in Secrets Manager. This command resolves the secret linked to the selected database and
+	prints username and password in one of the following formats:
+	* env: shell export statements (default)
+	* json: a JSON document
+	* pgpass: a line for the ~/.pgpass file
+	* my.cnf: a [client] section for the ~/.my.cnf file`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbCredentials()
+	},
+}
+
+var credentialsOutput string
+
+func init() {
+	dbCmd.AddCommand(dbCredentialsCmd)
+	dbCredentialsCmd.Flags().StringVarP(&dbIdentifier, "db-identifier", "d", "", "Optional identifier of the RDS instance, Aurora cluster or RDS Proxy endpoint. If not provided and several databases exist, a selection menu will open.")
+	dbCredentialsCmd.Flags().StringVarP(&dbEndpoint, "endpoint", "e", "", "Optional Aurora cluster endpoint: writer, reader or the name of a custom endpoint. Defaults to writer.")
+	dbCredentialsCmd.Flags().StringVarP(&credentialsOutput, "output", "o", "env", "Output format: env, json, pgpass or my.cnf.")
+	dbCredentialsCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Accept proposed defaults instead of prompting for missing values.")
+}
+
+// dbSecret holds the content of a database secret as stored by RDS in Secrets Manager.
+type dbSecret struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func dbCredentials() {
+	selectProfile()
+
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	db, err := getDBTarget(rds.NewFromConfig(cfg), dbIdentifier, dbEndpoint)
+	if err != nil {
+		log.Fatalf("unable to get RDS URL, %v.", err)
+	}
+
+	creds, err := getDBCredentials(cfg, db)
+	if err != nil {
+		log.Fatalf("unable to get database credentials, %v", err)
+	}
+
+	out, err := formatDBCredentials(credentialsOutput, db, creds)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Print(out)
+}
+
+// getDBCredentials fetches the secret linked to the database from Secrets Manager.
+func getDBCredentials(cfg aws.Config, db dbTarget) (dbSecret, error) {
+	if db.SecretArn == "" {
+		return dbSecret{}, fmt.Errorf("no secret is linked to database %s", db.Identifier)
+	}
+
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(context.TODO(), &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(db.SecretArn),
+	})
+	if err != nil {
+		return dbSecret{}, err
+	}
+
+	var creds dbSecret
+	if err := json.Unmarshal([]byte(aws.ToString(out.SecretString)), &creds); err != nil {
+		return dbSecret{}, fmt.Errorf("secret %s has an unknown format: %v", db.SecretArn, err)
+	}
+	return creds, nil
+}
+
+func formatDBCredentials(format string, db dbTarget, creds dbSecret) (string, error) {
+	switch format {
+	case "env":
+		return fmt.Sprintf("export DB_HOST=%s\nexport DB_PORT=%d\nexport DB_NAME=%s\nexport DB_USER=%s\nexport DB_PASSWORD=%s\n",
+			shellQuote(db.Address), db.Port, shellQuote(db.DBName), shellQuote(creds.Username), shellQuote(creds.Password)), nil
+	case "json":
+		out, err := json.MarshalIndent(map[string]interface{}{
+			"host":     db.Address,
+			"port":     db.Port,
+			"dbname":   db.DBName,
+			"engine":   db.Engine,
+			"username": creds.Username,
+			"password": creds.Password,
+		}, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(out) + "\n", nil
+	case "pgpass":
+		dbName := db.DBName
+		if dbName == "" {
+			dbName = "*"
+		}
+		return strings.Join([]string{
+			pgpassEscape(db.Address), fmt.Sprint(db.Port), pgpassEscape(dbName), pgpassEscape(creds.Username), pgpassEscape(creds.Password),
+		}, ":") + "\n", nil
+	case "my.cnf":
+		var b strings.Builder
+		b.WriteString("[client]\n")
+		fmt.Fprintf(&b, "host=%s\nport=%d\nuser=%s\npassword=%s\n", db.Address, db.Port, creds.Username, myCnfQuote(creds.Password))
+		if db.DBName != "" {
+			fmt.Fprintf(&b, "database=%s\n", db.DBName)
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("unknown output format %s, use one of env, json, pgpass or my.cnf", format)
+}
+
+func printCredentialsConnection(cfg aws.Config, db dbTarget, localPort int) {
+	creds, err := getDBCredentials(cfg, db)
+	if err != nil {
+		log.Fatalf("unable to get database credentials, %v", err)
+	}
+
+	fmt.Printf("Connection string: %s\n\n", db.connectionURI(creds.Username, creds.Password, localPort))
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// pgpassEscape escapes the field separator and backslashes as required by the .pgpass format.
+func pgpassEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `:`, `\:`).Replace(s)
+}
+
+// myCnfQuote quotes an option value of a MySQL option file.
+func myCnfQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/rds/auth"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/spf13/cobra"
+)
+
+// dbTokenCmd represents the db token command
+var dbTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Generate an IAM database authentication token for the RDS database.",
+	Long: `Generate an IAM database authentication token for the RDS database, using the selected profile.
+	The token is valid for 15 minutes and can be used as the password of the database user, also through
+	a port-forward opened with "terra3 db port-forward". IAM database authentication needs to be enabled
+	for the database.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbToken()
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbTokenCmd)
+	dbTokenCmd.Flags().StringVarP(&dbIdentifier, "db-identifier", "d", "", "Optional identifier of the RDS instance, Aurora cluster or RDS Proxy endpoint. If not provided and several databases exist, a selection menu will open.")
+	dbTokenCmd.Flags().StringVarP(&dbEndpoint, "endpoint", "e", "", "Optional Aurora cluster endpoint: writer, reader or the name of a custom endpoint. Defaults to writer.")
+	dbTokenCmd.Flags().StringVarP(&dbUser, "db-user", "u", "", "Optional database user. Defaults to the master user of the database.")
+	dbTokenCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Accept proposed defaults instead of prompting for missing values.")
+}
+
+func dbToken() {
+	selectProfile()
+
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	db, err := getDBTarget(rds.NewFromConfig(cfg), dbIdentifier, dbEndpoint)
+	if err != nil {
+		log.Fatalf("unable to get RDS URL, %v.", err)
+	}
+
+	token, _, err := buildIAMAuthToken(cfg, db)
+	if err != nil {
+		log.Fatalf("unable to generate IAM auth token, %v", err)
+	}
+
+	fmt.Println(token)
+}
+
+// buildIAMAuthToken generates an authentication token for the database user given by --db-user, falling back to
+// the master user. The token is signed for the real endpoint of the database, as RDS checks it against the
+// hostname the token was created for. It is not valid for localhost, but can be used through a port-forward.
+func buildIAMAuthToken(cfg aws.Config, db dbTarget) (token string, user string, err error) {
+	if !db.IAMAuth {
+		return "", "", fmt.Errorf("IAM database authentication is not enabled for %s", db.Identifier)
+	}
+
+	user = dbUser
+	if user == "" {
+		user = db.MasterUsername
+	}
+	if user == "" {
+		return "", "", fmt.Errorf("unable to determine the database user of %s, please provide one with --db-user", db.Identifier)
+	}
+
+	endpoint := fmt.Sprintf("%s:%d", db.Address, db.Port)
+	token, err = auth.BuildAuthToken(context.TODO(), endpoint, cfg.Region, user, cfg.Credentials)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, user, nil
+}
+
+func printIAMAuthConnection(cfg aws.Config, db dbTarget, localPort int) {
+	token, user, err := buildIAMAuthToken(cfg, db)
+	if err != nil {
+		log.Fatalf("unable to generate IAM auth token, %v", err)
+	}
+
+	fmt.Printf("IAM auth token for user %s (valid for 15 minutes):\n%s\n\n", user, token)
+	fmt.Printf("Connection string: %s\n\n", db.connectionURI(user, token, localPort))
+}
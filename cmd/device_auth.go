@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	oidctypes "github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
+)
+
+// oidcClient is the part of the SSO OIDC API used by the device authorization flow. It is satisfied by
+// *ssooidc.Client and can be replaced by a stub.
+type oidcClient interface {
+	RegisterClient(ctx context.Context, params *ssooidc.RegisterClientInput, optFns ...func(*ssooidc.Options)) (*ssooidc.RegisterClientOutput, error)
+	StartDeviceAuthorization(ctx context.Context, params *ssooidc.StartDeviceAuthorizationInput, optFns ...func(*ssooidc.Options)) (*ssooidc.StartDeviceAuthorizationOutput, error)
+	CreateToken(ctx context.Context, params *ssooidc.CreateTokenInput, optFns ...func(*ssooidc.Options)) (*ssooidc.CreateTokenOutput, error)
+}
+
+const (
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// defaults if the authorization response leaves them out, as in RFC 8628
+	defaultPollInterval    = 5 * time.Second
+	defaultDeviceCodeValid = 10 * time.Minute
+	// added to the poll interval for each slow_down response, as in RFC 8628
+	slowDownIncrement = 5 * time.Second
+)
+
+var (
+	ErrAuthorizationDenied = errors.New("the authorization was denied in the browser")
+	ErrDeviceCodeExpired   = errors.New("the authorization was not completed in time")
+)
+
+// deviceAuthFlow performs the OAuth device authorization flow against IAM Identity Center.
+type deviceAuthFlow struct {
+	client oidcClient
+	// openURL opens the verification URL in the browser; errors are not fatal, the URL is printed as well
+	openURL func(url string) error
+	// after waits for the poll interval; if nil, time.After is used
+	after func(d time.Duration) <-chan time.Time
+}
+
+// login registers the client, starts the device authorization for the start URL and waits until the user has
+// approved it in the browser. It returns the registration, which is needed to refresh the token later on.
+func (f deviceAuthFlow) login(ctx context.Context, startURL string) (*ssooidc.RegisterClientOutput, *ssooidc.CreateTokenOutput, error) {
+	// with the scope, a refresh token is issued, which lets the SDKs renew the session without another login
+	register, err := f.client.RegisterClient(ctx, &ssooidc.RegisterClientInput{
+		ClientName: aws.String("terra3-cli-client"),
+		ClientType: aws.String("public"),
+		Scopes:     []string{ssoRegistrationScope},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to register client, %w", err)
+	}
+
+	deviceAuth, err := f.client.StartDeviceAuthorization(ctx, &ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     register.ClientId,
+		ClientSecret: register.ClientSecret,
+		StartUrl:     aws.String(startURL),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to start device authorization, %w", err)
+	}
+
+	url := aws.ToString(deviceAuth.VerificationUriComplete)
+	fmt.Fprintf(os.Stderr, "If your browser is not opened automatically, please open link:\n%v\n", url)
+	fmt.Fprintf(os.Stderr, "Verification code: %s\n", aws.ToString(deviceAuth.UserCode))
+	if f.openURL != nil {
+		if err := f.openURL(url); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open browser, %v\n", err)
+		}
+	}
+
+	token, err := f.poll(ctx, register, deviceAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	return register, token, nil
+}
+
+// poll asks for the token until the authorization is approved, denied or has expired. The interval is increased
+// whenever the service asks to slow down. Cancelling the context stops the polling, returning its cause.
+func (f deviceAuthFlow) poll(ctx context.Context, register *ssooidc.RegisterClientOutput, deviceAuth *ssooidc.StartDeviceAuthorizationOutput) (*ssooidc.CreateTokenOutput, error) {
+	interval := time.Duration(deviceAuth.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	validFor := time.Duration(deviceAuth.ExpiresIn) * time.Second
+	if validFor <= 0 {
+		validFor = defaultDeviceCodeValid
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, validFor, ErrDeviceCodeExpired)
+	defer cancel()
+
+	after := f.after
+	if after == nil {
+		after = time.After
+	}
+
+	fmt.Fprint(os.Stderr, "Waiting for authorization")
+	defer fmt.Fprintln(os.Stderr)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-after(interval):
+		}
+
+		token, err := f.client.CreateToken(ctx, &ssooidc.CreateTokenInput{
+			ClientId:     register.ClientId,
+			ClientSecret: register.ClientSecret,
+			DeviceCode:   deviceAuth.DeviceCode,
+			GrantType:    aws.String(deviceCodeGrantType),
+		})
+
+		var (
+			pending      *oidctypes.AuthorizationPendingException
+			slowDown     *oidctypes.SlowDownException
+			accessDenied *oidctypes.AccessDeniedException
+			expired      *oidctypes.ExpiredTokenException
+		)
+		switch {
+		case err == nil:
+			return token, nil
+		case ctx.Err() != nil:
+			// the request was aborted by the deadline or an interrupt
+			return nil, context.Cause(ctx)
+		case errors.As(err, &pending):
+		case errors.As(err, &slowDown):
+			interval += slowDownIncrement
+		case errors.As(err, &accessDenied):
+			return nil, ErrAuthorizationDenied
+		case errors.As(err, &expired):
+			return nil, ErrDeviceCodeExpired
+		default:
+			return nil, fmt.Errorf("unable to create token, %w", err)
+		}
+
+		fmt.Fprint(os.Stderr, ".")
+	}
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	oidctypes "github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
+)
+
+// fakeOIDCClient answers CreateToken with the given errors, one per call, and issues a token afterwards.
+type fakeOIDCClient struct {
+	errs   []error
+	calls  int
+	onCall func(call int)
+}
+
+func (c *fakeOIDCClient) RegisterClient(ctx context.Context, params *ssooidc.RegisterClientInput, optFns ...func(*ssooidc.Options)) (*ssooidc.RegisterClientOutput, error) {
+	return &ssooidc.RegisterClientOutput{ClientId: aws.String("client"), ClientSecret: aws.String("secret")}, nil
+}
+
+func (c *fakeOIDCClient) StartDeviceAuthorization(ctx context.Context, params *ssooidc.StartDeviceAuthorizationInput, optFns ...func(*ssooidc.Options)) (*ssooidc.StartDeviceAuthorizationOutput, error) {
+	return &ssooidc.StartDeviceAuthorizationOutput{
+		DeviceCode:              aws.String("device-code"),
+		UserCode:                aws.String("ABCD-EFGH"),
+		VerificationUriComplete: aws.String("https://device.sso.example.com/?user_code=ABCD-EFGH"),
+		Interval:                1,
+		ExpiresIn:               600,
+	}, nil
+}
+
+func (c *fakeOIDCClient) CreateToken(ctx context.Context, params *ssooidc.CreateTokenInput, optFns ...func(*ssooidc.Options)) (*ssooidc.CreateTokenOutput, error) {
+	c.calls++
+	if c.onCall != nil {
+		c.onCall(c.calls)
+	}
+	if aws.ToString(params.GrantType) != deviceCodeGrantType || aws.ToString(params.DeviceCode) != "device-code" {
+		return nil, errors.New("unexpected token request")
+	}
+	if c.calls <= len(c.errs) {
+		return nil, c.errs[c.calls-1]
+	}
+	return &ssooidc.CreateTokenOutput{AccessToken: aws.String("access-token"), ExpiresIn: 3600}, nil
+}
+
+// immediately returns a wait function which does not wait, recording the requested intervals.
+func immediately(intervals *[]time.Duration) func(time.Duration) <-chan time.Time {
+	return func(d time.Duration) <-chan time.Time {
+		*intervals = append(*intervals, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+}
+
+func TestDeviceAuthFlowPending(t *testing.T) {
+	client := &fakeOIDCClient{errs: []error{
+		&oidctypes.AuthorizationPendingException{},
+		&oidctypes.AuthorizationPendingException{},
+	}}
+	var intervals []time.Duration
+	flow := deviceAuthFlow{client: client, after: immediately(&intervals)}
+
+	register, token, err := flow.login(context.Background(), "https://example.awsapps.com/start")
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if aws.ToString(register.ClientId) != "client" {
+		t.Errorf("client ID = %q, want client", aws.ToString(register.ClientId))
+	}
+	if aws.ToString(token.AccessToken) != "access-token" {
+		t.Errorf("access token = %q, want access-token", aws.ToString(token.AccessToken))
+	}
+	if client.calls != 3 {
+		t.Errorf("CreateToken called %d times, want 3", client.calls)
+	}
+	for _, d := range intervals {
+		if d != time.Second {
+			t.Errorf("interval = %v, want 1s", d)
+		}
+	}
+}
+
+func TestDeviceAuthFlowSlowDown(t *testing.T) {
+	client := &fakeOIDCClient{errs: []error{
+		&oidctypes.SlowDownException{},
+		&oidctypes.AuthorizationPendingException{},
+		&oidctypes.SlowDownException{},
+	}}
+	var intervals []time.Duration
+	flow := deviceAuthFlow{client: client, after: immediately(&intervals)}
+
+	if _, _, err := flow.login(context.Background(), "https://example.awsapps.com/start"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	want := []time.Duration{1 * time.Second, 6 * time.Second, 6 * time.Second, 11 * time.Second}
+	if len(intervals) != len(want) {
+		t.Fatalf("intervals = %v, want %v", intervals, want)
+	}
+	for i := range want {
+		if intervals[i] != want[i] {
+			t.Errorf("intervals = %v, want %v", intervals, want)
+			break
+		}
+	}
+}
+
+func TestDeviceAuthFlowErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"access denied", &oidctypes.AccessDeniedException{}, ErrAuthorizationDenied},
+		{"expired token", &oidctypes.ExpiredTokenException{}, ErrDeviceCodeExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeOIDCClient{errs: []error{&oidctypes.AuthorizationPendingException{}, tt.err}}
+			var intervals []time.Duration
+			flow := deviceAuthFlow{client: client, after: immediately(&intervals)}
+
+			_, _, err := flow.login(context.Background(), "https://example.awsapps.com/start")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+			if client.calls != 2 {
+				t.Errorf("CreateToken called %d times, want 2", client.calls)
+			}
+		})
+	}
+}
+
+func TestDeviceAuthFlowDeadline(t *testing.T) {
+	client := &fakeOIDCClient{}
+	// the interval never passes, so only the validity of the device code ends the polling
+	flow := deviceAuthFlow{client: client, after: func(time.Duration) <-chan time.Time { return nil }}
+
+	register, _ := client.RegisterClient(context.Background(), nil)
+	deviceAuth := &ssooidc.StartDeviceAuthorizationOutput{DeviceCode: aws.String("device-code"), Interval: 1, ExpiresIn: 1}
+
+	_, err := flow.poll(context.Background(), register, deviceAuth)
+	if !errors.Is(err, ErrDeviceCodeExpired) {
+		t.Errorf("error = %v, want %v", err, ErrDeviceCodeExpired)
+	}
+	if client.calls != 0 {
+		t.Errorf("CreateToken called %d times, want 0", client.calls)
+	}
+}
+
+func TestDeviceAuthFlowCancel(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	interrupted := errors.New("interrupted")
+
+	// the user interrupts while the authorization is still pending
+	client := &fakeOIDCClient{
+		errs: []error{&oidctypes.AuthorizationPendingException{}, &oidctypes.AuthorizationPendingException{}},
+		onCall: func(call int) {
+			if call == 2 {
+				cancel(interrupted)
+			}
+		},
+	}
+	var intervals []time.Duration
+	flow := deviceAuthFlow{client: client, after: immediately(&intervals)}
+
+	_, _, err := flow.login(ctx, "https://example.awsapps.com/start")
+	if !errors.Is(err, interrupted) {
+		t.Errorf("error = %v, want %v", err, interrupted)
+	}
+	if client.calls != 2 {
+		t.Errorf("CreateToken called %d times, want 2", client.calls)
+	}
+}
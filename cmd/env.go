@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	astypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/spf13/cobra"
+)
+
+// envCmd represents the env command
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Manage the hibernation of your environment.",
+	Long: `Manage the hibernation of your environment to save costs while it is not used. The resources of the
+	environment are found by the tag given with --tag, e.g. --tag environment=staging. Use one of the sub-commands.
+	* stop: Hibernate the environment.
+	* start: Wake the environment up again.
+	* status: Show whether the environment is hibernated and the state of its resources.
+	`,
+}
+
+var envStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Hibernate the environment.",
+	Long: `Hibernate the environment: ECS services are scaled to zero, RDS instances and Aurora clusters are stopped
+	and Auto Scaling groups, like those of the bastion host and NAT instances, are scaled to zero. The previous
+	state is saved in an SSM parameter, so "terra3 env start" restores it exactly. Note that AWS starts stopped
+	databases again after seven days.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		envStop()
+	},
+}
+
+var envStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Wake the environment up from hibernation.",
+	Long: `Wake the environment up from hibernation, restoring the state saved by "terra3 env stop". Auto Scaling
+	groups are restored first, then the databases are started. Unless --no-wait is given, the ECS services are
+	only scaled up once the databases are available.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		envStart()
+	},
+}
+
+var envStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the hibernation status of the environment.",
+	Long:  `Show whether the environment is hibernated and the state of its ECS services, databases and Auto Scaling groups.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		envStatus()
+	},
+}
+
+var (
+	envTag    string
+	envNoWait bool
+)
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+	envCmd.AddCommand(envStopCmd)
+	envCmd.AddCommand(envStartCmd)
+	envCmd.AddCommand(envStatusCmd)
+	envCmd.PersistentFlags().StringVarP(&envTag, "tag", "t", "", "Tag identifying the resources of the environment, as key=value.")
+	envStopCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Hibernate without asking for confirmation.")
+	envStartCmd.Flags().BoolVar(&envNoWait, "no-wait", false, "Scale the ECS services up without waiting for the databases to be available.")
+}
+
+// envResources are the resources of an environment that are affected by hibernation.
+type envResources struct {
+	Services          []ecsServiceState
+	DBInstances       []dbResourceState
+	DBClusters        []dbResourceState
+	AutoScalingGroups []asgState
+}
+
+type ecsServiceState struct {
+	Cluster      string `json:"cluster"`
+	Service      string `json:"service"`
+	DesiredCount int32  `json:"desiredCount"`
+	RunningCount int32  `json:"-"`
+}
+
+type dbResourceState struct {
+	Identifier string
+	Status     string
+}
+
+type asgState struct {
+	Name            string `json:"name"`
+	MinSize         int32  `json:"minSize"`
+	MaxSize         int32  `json:"maxSize"`
+	DesiredCapacity int32  `json:"desiredCapacity"`
+	Instances       int    `json:"-"`
+}
+
+// envSetup selects the profile, loads the SDK config and parses --tag.
+func envSetup() (cfg aws.Config, key string, value string) {
+	if envTag == "" {
+		log.Fatalf("please identify the environment with --tag key=value")
+	}
+	key, value, err := parseTag("--tag", envTag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	selectProfile()
+
+	cfg, err = loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+	return cfg, key, value
+}
+
+// discoverEnvResources finds the resources tagged with key=value. ECS services are included if either the
+// service or its cluster carries the tag. RDS instances belonging to a cluster are left out, since they are
+// stopped and started with their cluster.
+func discoverEnvResources(cfg aws.Config, key string, value string) (envResources, error) {
+	var res envResources
+	var err error
+
+	if res.Services, err = discoverECSServices(ecs.NewFromConfig(cfg), key, value); err != nil {
+		return res, fmt.Errorf("unable to list ECS services, %w", err)
+	}
+	if res.DBInstances, res.DBClusters, err = discoverDBResources(rds.NewFromConfig(cfg), key, value); err != nil {
+		return res, fmt.Errorf("unable to list RDS databases, %w", err)
+	}
+	if res.AutoScalingGroups, err = discoverAutoScalingGroups(autoscaling.NewFromConfig(cfg), key, value); err != nil {
+		return res, fmt.Errorf("unable to list Auto Scaling groups, %w", err)
+	}
+	return res, nil
+}
+
+func discoverECSServices(client *ecs.Client, key string, value string) ([]ecsServiceState, error) {
+	var clusterArns []string
+	clusters := ecs.NewListClustersPaginator(client, &ecs.ListClustersInput{})
+	for clusters.HasMorePages() {
+		page, err := clusters.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		clusterArns = append(clusterArns, page.ClusterArns...)
+	}
+
+	var result []ecsServiceState
+	for _, clusterArn := range clusterArns {
+		resp, err := client.DescribeClusters(context.TODO(), &ecs.DescribeClustersInput{
+			Clusters: []string{clusterArn},
+			Include:  []ecstypes.ClusterField{ecstypes.ClusterFieldTags},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Clusters) == 0 {
+			continue
+		}
+		cluster := resp.Clusters[0]
+		clusterTagged := hasECSTag(cluster.Tags, key, value)
+
+		var serviceArns []string
+		services := ecs.NewListServicesPaginator(client, &ecs.ListServicesInput{Cluster: cluster.ClusterName})
+		for services.HasMorePages() {
+			page, err := services.NextPage(context.TODO())
+			if err != nil {
+				return nil, err
+			}
+			serviceArns = append(serviceArns, page.ServiceArns...)
+		}
+
+		// DescribeServices accepts up to 10 services per call
+		for start := 0; start < len(serviceArns); start += 10 {
+			end := min(start+10, len(serviceArns))
+			resp, err := client.DescribeServices(context.TODO(), &ecs.DescribeServicesInput{
+				Cluster:  cluster.ClusterName,
+				Services: serviceArns[start:end],
+				Include:  []ecstypes.ServiceField{ecstypes.ServiceFieldTags},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			for _, service := range resp.Services {
+				if !clusterTagged && !hasECSTag(service.Tags, key, value) {
+					continue
+				}
+				result = append(result, ecsServiceState{
+					Cluster:      aws.ToString(cluster.ClusterName),
+					Service:      aws.ToString(service.ServiceName),
+					DesiredCount: service.DesiredCount,
+					RunningCount: service.RunningCount,
+				})
+			}
+		}
+	}
+	return result, nil
+}
+
+func hasECSTag(tags []ecstypes.Tag, key string, value string) bool {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key && aws.ToString(tag.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func discoverDBResources(client *rds.Client, key string, value string) (instances []dbResourceState, clusters []dbResourceState, err error) {
+	instancePages := rds.NewDescribeDBInstancesPaginator(client, &rds.DescribeDBInstancesInput{})
+	for instancePages.HasMorePages() {
+		page, err := instancePages.NextPage(context.TODO())
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, instance := range page.DBInstances {
+			if instance.DBClusterIdentifier != nil {
+				continue
+			}
+			for _, tag := range instance.TagList {
+				if aws.ToString(tag.Key) == key && aws.ToString(tag.Value) == value {
+					instances = append(instances, dbResourceState{aws.ToString(instance.DBInstanceIdentifier), aws.ToString(instance.DBInstanceStatus)})
+					break
+				}
+			}
+		}
+	}
+
+	clusterPages := rds.NewDescribeDBClustersPaginator(client, &rds.DescribeDBClustersInput{})
+	for clusterPages.HasMorePages() {
+		page, err := clusterPages.NextPage(context.TODO())
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, cluster := range page.DBClusters {
+			for _, tag := range cluster.TagList {
+				if aws.ToString(tag.Key) == key && aws.ToString(tag.Value) == value {
+					clusters = append(clusters, dbResourceState{aws.ToString(cluster.DBClusterIdentifier), aws.ToString(cluster.Status)})
+					break
+				}
+			}
+		}
+	}
+	return instances, clusters, nil
+}
+
+func discoverAutoScalingGroups(client *autoscaling.Client, key string, value string) ([]asgState, error) {
+	var result []asgState
+	paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(client, &autoscaling.DescribeAutoScalingGroupsInput{
+		Filters: []astypes.Filter{{Name: aws.String("tag:" + key), Values: []string{value}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page.AutoScalingGroups {
+			result = append(result, asgState{
+				Name:            aws.ToString(group.AutoScalingGroupName),
+				MinSize:         aws.ToInt32(group.MinSize),
+				MaxSize:         aws.ToInt32(group.MaxSize),
+				DesiredCapacity: aws.ToInt32(group.DesiredCapacity),
+				Instances:       len(group.Instances),
+			})
+		}
+	}
+	return result, nil
+}
+
+func envStatus() {
+	cfg, key, value := envSetup()
+
+	state, err := loadHibernationState(ssm.NewFromConfig(cfg), key, value)
+	if err != nil {
+		log.Fatalf("unable to load hibernation state, %v", err)
+	}
+
+	res, err := discoverEnvResources(cfg, key, value)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if state != nil {
+		fmt.Printf("Environment %s=%s is hibernated since %s.\n\n", key, value, state.StoppedAt.Local().Format("2006-01-02 15:04:05"))
+	} else {
+		fmt.Printf("Environment %s=%s is not hibernated.\n\n", key, value)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tSTATUS")
+	for _, s := range res.Services {
+		fmt.Fprintf(w, "ECS service\t%s/%s\t%d/%d tasks running\n", s.Cluster, s.Service, s.RunningCount, s.DesiredCount)
+	}
+	for _, db := range res.DBClusters {
+		fmt.Fprintf(w, "RDS cluster\t%s\t%s\n", db.Identifier, db.Status)
+	}
+	for _, db := range res.DBInstances {
+		fmt.Fprintf(w, "RDS instance\t%s\t%s\n", db.Identifier, db.Status)
+	}
+	for _, g := range res.AutoScalingGroups {
+		fmt.Fprintf(w, "Auto Scaling group\t%s\t%d/%d instances (min %d, max %d)\n", g.Name, g.Instances, g.DesiredCapacity, g.MinSize, g.MaxSize)
+	}
+	w.Flush()
+}
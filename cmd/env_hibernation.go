@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/manifoldco/promptui"
+)
+
+// dbStartTimeout is the time to wait for started databases to become available.
+const dbStartTimeout = 30 * time.Minute
+
+// hibernationState is the state of an environment before it was hibernated, saved in an SSM parameter.
+type hibernationState struct {
+	StoppedAt         time.Time         `json:"stoppedAt"`
+	Services          []ecsServiceState `json:"ecsServices"`
+	DBInstances       []string          `json:"dbInstances"`
+	DBClusters        []string          `json:"dbClusters"`
+	AutoScalingGroups []asgState        `json:"autoScalingGroups"`
+}
+
+// merge adds the running resources which are not part of the state yet. It reports whether anything was added.
+func (s *hibernationState) merge(res envResources) bool {
+	changed := false
+
+	for _, service := range res.Services {
+		known := slices.ContainsFunc(s.Services, func(known ecsServiceState) bool {
+			return known.Cluster == service.Cluster && known.Service == service.Service
+		})
+		if !known && service.DesiredCount > 0 {
+			s.Services = append(s.Services, service)
+			changed = true
+		}
+	}
+	for _, db := range res.DBInstances {
+		if !slices.Contains(s.DBInstances, db.Identifier) && db.Status == "available" {
+			s.DBInstances = append(s.DBInstances, db.Identifier)
+			changed = true
+		}
+	}
+	for _, db := range res.DBClusters {
+		if !slices.Contains(s.DBClusters, db.Identifier) && db.Status == "available" {
+			s.DBClusters = append(s.DBClusters, db.Identifier)
+			changed = true
+		}
+	}
+	for _, group := range res.AutoScalingGroups {
+		known := slices.ContainsFunc(s.AutoScalingGroups, func(known asgState) bool {
+			return known.Name == group.Name
+		})
+		if !known && (group.MinSize > 0 || group.DesiredCapacity > 0) {
+			s.AutoScalingGroups = append(s.AutoScalingGroups, group)
+			changed = true
+		}
+	}
+	return changed
+}
+
+var invalidParameterChars = regexp.MustCompile(`[^a-zA-Z0-9_.\-]`)
+
+// hibernationParameterName returns the name of the SSM parameter holding the hibernation state of the environment.
+func hibernationParameterName(key string, value string) string {
+	return fmt.Sprintf("/terra3/hibernation/%s/%s", invalidParameterChars.ReplaceAllString(key, "_"), invalidParameterChars.ReplaceAllString(value, "_"))
+}
+
+// loadHibernationState returns the saved hibernation state, or nil if the environment is not hibernated.
+func loadHibernationState(client *ssm.Client, key string, value string) (*hibernationState, error) {
+	resp, err := client.GetParameter(context.TODO(), &ssm.GetParameterInput{
+		Name: aws.String(hibernationParameterName(key, value)),
+	})
+	var notFound *ssmtypes.ParameterNotFound
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := new(hibernationState)
+	if err := json.Unmarshal([]byte(aws.ToString(resp.Parameter.Value)), state); err != nil {
+		return nil, fmt.Errorf("parameter %s is corrupt, %w", aws.ToString(resp.Parameter.Name), err)
+	}
+	return state, nil
+}
+
+func saveHibernationState(client *ssm.Client, key string, value string, state *hibernationState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutParameter(context.TODO(), &ssm.PutParameterInput{
+		Name:        aws.String(hibernationParameterName(key, value)),
+		Description: aws.String(fmt.Sprintf("State of the Terra3 environment %s=%s before hibernation", key, value)),
+		Value:       aws.String(string(b)),
+		Type:        ssmtypes.ParameterTypeString,
+		Tier:        ssmtypes.ParameterTierIntelligentTiering,
+		Overwrite:   aws.Bool(true),
+	})
+	return err
+}
+
+func envStop() {
+	cfg, key, value := envSetup()
+	ssmClient := ssm.NewFromConfig(cfg)
+
+	state, err := loadHibernationState(ssmClient, key, value)
+	if err != nil {
+		log.Fatalf("unable to load hibernation state, %v", err)
+	}
+
+	res, err := discoverEnvResources(cfg, key, value)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// stopping again continues an interrupted hibernation, keeping the state saved the first time
+	resumed := state != nil
+	if !resumed {
+		state = &hibernationState{StoppedAt: time.Now()}
+	}
+	changed := state.merge(res)
+
+	if !resumed {
+		if !changed {
+			fmt.Printf("Nothing to hibernate, no running resources tagged %s=%s found.\n", key, value)
+			return
+		}
+		confirmHibernation(state)
+	}
+
+	if changed {
+		if err := saveHibernationState(ssmClient, key, value, state); err != nil {
+			log.Fatalf("unable to save hibernation state, %v", err)
+		}
+	}
+
+	var errs []error
+	ecsClient := ecs.NewFromConfig(cfg)
+	for _, s := range state.Services {
+		fmt.Printf("Scaling ECS service %s/%s to 0...\n", s.Cluster, s.Service)
+		_, err := ecsClient.UpdateService(context.TODO(), &ecs.UpdateServiceInput{
+			Cluster:      aws.String(s.Cluster),
+			Service:      aws.String(s.Service),
+			DesiredCount: aws.Int32(0),
+		})
+		errs = append(errs, err)
+	}
+
+	rdsClient := rds.NewFromConfig(cfg)
+	for _, db := range res.DBClusters {
+		if slices.Contains(state.DBClusters, db.Identifier) && db.Status == "available" {
+			fmt.Printf("Stopping RDS cluster %s...\n", db.Identifier)
+			_, err := rdsClient.StopDBCluster(context.TODO(), &rds.StopDBClusterInput{DBClusterIdentifier: aws.String(db.Identifier)})
+			errs = append(errs, err)
+		}
+	}
+	for _, db := range res.DBInstances {
+		if slices.Contains(state.DBInstances, db.Identifier) && db.Status == "available" {
+			fmt.Printf("Stopping RDS instance %s...\n", db.Identifier)
+			_, err := rdsClient.StopDBInstance(context.TODO(), &rds.StopDBInstanceInput{DBInstanceIdentifier: aws.String(db.Identifier)})
+			errs = append(errs, err)
+		}
+	}
+
+	asClient := autoscaling.NewFromConfig(cfg)
+	for _, g := range state.AutoScalingGroups {
+		fmt.Printf("Scaling Auto Scaling group %s to 0...\n", g.Name)
+		_, err := asClient.UpdateAutoScalingGroup(context.TODO(), &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(g.Name),
+			MinSize:              aws.Int32(0),
+			DesiredCapacity:      aws.Int32(0),
+		})
+		errs = append(errs, err)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		log.Fatalf("hibernation incomplete, run \"terra3 env stop\" again to retry:\n%v", err)
+	}
+	fmt.Printf("Environment %s=%s hibernated. Note that AWS starts stopped databases again after seven days.\n", key, value)
+}
+
+// confirmHibernation lists what is about to be stopped and asks for confirmation, unless --yes is given.
+func confirmHibernation(state *hibernationState) {
+	fmt.Println("The following resources will be stopped:")
+	for _, s := range state.Services {
+		fmt.Printf("  ECS service %s/%s (%d tasks)\n", s.Cluster, s.Service, s.DesiredCount)
+	}
+	for _, id := range state.DBClusters {
+		fmt.Printf("  RDS cluster %s\n", id)
+	}
+	for _, id := range state.DBInstances {
+		fmt.Printf("  RDS instance %s\n", id)
+	}
+	for _, g := range state.AutoScalingGroups {
+		fmt.Printf("  Auto Scaling group %s (%d instances)\n", g.Name, g.DesiredCapacity)
+	}
+
+	if assumeYes {
+		return
+	}
+	if !isInteractive() {
+		log.Fatalf("stdin is not a terminal, please confirm with --yes")
+	}
+
+	prompt := promptui.Prompt{
+		Label:     "Hibernate environment",
+		IsConfirm: true,
+	}
+	if _, err := prompt.Run(); err != nil {
+		log.Fatalf("aborted")
+	}
+}
+
+func envStart() {
+	cfg, key, value := envSetup()
+	ssmClient := ssm.NewFromConfig(cfg)
+
+	state, err := loadHibernationState(ssmClient, key, value)
+	if err != nil {
+		log.Fatalf("unable to load hibernation state, %v", err)
+	}
+	if state == nil {
+		log.Fatalf("environment %s=%s is not hibernated", key, value)
+	}
+
+	var errs []error
+	asClient := autoscaling.NewFromConfig(cfg)
+	for _, g := range state.AutoScalingGroups {
+		fmt.Printf("Restoring Auto Scaling group %s to %d instances...\n", g.Name, g.DesiredCapacity)
+		_, err := asClient.UpdateAutoScalingGroup(context.TODO(), &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(g.Name),
+			MinSize:              aws.Int32(g.MinSize),
+			MaxSize:              aws.Int32(g.MaxSize),
+			DesiredCapacity:      aws.Int32(g.DesiredCapacity),
+		})
+		errs = append(errs, err)
+	}
+
+	rdsClient := rds.NewFromConfig(cfg)
+	for _, id := range state.DBClusters {
+		fmt.Printf("Starting RDS cluster %s...\n", id)
+		_, err := rdsClient.StartDBCluster(context.TODO(), &rds.StartDBClusterInput{DBClusterIdentifier: aws.String(id)})
+		errs = append(errs, ignoreAlreadyStarted(err))
+	}
+	for _, id := range state.DBInstances {
+		fmt.Printf("Starting RDS instance %s...\n", id)
+		_, err := rdsClient.StartDBInstance(context.TODO(), &rds.StartDBInstanceInput{DBInstanceIdentifier: aws.String(id)})
+		errs = append(errs, ignoreAlreadyStarted(err))
+	}
+
+	if !envNoWait && len(state.DBClusters)+len(state.DBInstances) > 0 {
+		fmt.Println("Waiting for the databases to become available, this usually takes a few minutes...")
+		for _, id := range state.DBClusters {
+			errs = append(errs, rds.NewDBClusterAvailableWaiter(rdsClient).Wait(context.TODO(),
+				&rds.DescribeDBClustersInput{DBClusterIdentifier: aws.String(id)}, dbStartTimeout))
+		}
+		for _, id := range state.DBInstances {
+			errs = append(errs, rds.NewDBInstanceAvailableWaiter(rdsClient).Wait(context.TODO(),
+				&rds.DescribeDBInstancesInput{DBInstanceIdentifier: aws.String(id)}, dbStartTimeout))
+		}
+	}
+
+	ecsClient := ecs.NewFromConfig(cfg)
+	for _, s := range state.Services {
+		fmt.Printf("Restoring ECS service %s/%s to %d tasks...\n", s.Cluster, s.Service, s.DesiredCount)
+		_, err := ecsClient.UpdateService(context.TODO(), &ecs.UpdateServiceInput{
+			Cluster:      aws.String(s.Cluster),
+			Service:      aws.String(s.Service),
+			DesiredCount: aws.Int32(s.DesiredCount),
+		})
+		errs = append(errs, err)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		log.Fatalf("wake-up incomplete, run \"terra3 env start\" again to retry:\n%v", err)
+	}
+
+	_, err = ssmClient.DeleteParameter(context.TODO(), &ssm.DeleteParameterInput{
+		Name: aws.String(hibernationParameterName(key, value)),
+	})
+	if err != nil {
+		log.Fatalf("environment started, but unable to delete the hibernation state, %v", err)
+	}
+	fmt.Printf("Environment %s=%s started.\n", key, value)
+}
+
+// ignoreAlreadyStarted drops the error of starting a database that is not stopped, which happens when retrying
+// an incomplete wake-up or when AWS started the database after seven days.
+func ignoreAlreadyStarted(err error) error {
+	var instanceState *rdstypes.InvalidDBInstanceStateFault
+	var clusterState *rdstypes.InvalidDBClusterStateFault
+	if errors.As(err, &instanceState) || errors.As(err, &clusterState) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,395 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/it-objects/terra3-cli/ssmclient"
+	"github.com/manifoldco/promptui"
+	"github.com/pkg/browser"
+	"github.com/spf13/cobra"
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Built-in OIDC login without requiring the AWS CLI. (experimental)",
+	Long: `Built-in OIDC login without requiring the AWS CLI. This feature is experimental.
+
+	The session is stored in the SSO token cache in ~/.aws/sso/cache, where the AWS SDKs and the AWS CLI pick it
+	up. Afterwards, an account and one of your roles in it are selected. The credentials of the role can be
+	printed as environment variables, written as a profile to the AWS config or used for a port-forward to the
+	database right away. With --profile-name, the profile written for the role gets that name. Profiles for all
+	accounts and roles can be written to the AWS config as well.
+
+	The login does not use a profile, so it works without an AWS config and with AWS_PROFILE naming a profile
+	that does not exist yet.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		login()
+	},
+}
+
+var loginProfileName string
+
+func init() {
+	loginCmd.Flags().StringVar(&loginProfileName, "profile-name", "", "Optional name of the profile written for the selected role. Defaults to <account name>-<role name>.")
+}
+
+// add array of constants containing all AWS regions available
+var awsRegions = []string{
+	"us-east-1",
+	"us-east-2",
+	"us-west-1",
+	"us-west-2",
+	"ap-east-1",
+	"ap-south-1",
+	"ap-northeast-1",
+	"ap-northeast-2",
+	"ap-northeast-3",
+	"ap-southeast-1",
+	"ap-southeast-2",
+	"ca-central-1",
+	"cn-north-1",
+	"cn-northwest-1",
+	"eu-central-1",
+	"eu-central-2",
+	"eu-west-1",
+	"eu-west-2",
+	"eu-west-3",
+	"eu-north-1",
+	"il-central-1",
+	"me-south-1",
+	"sa-east-1",
+	"me-central-1",
+	"us-gov-east-1",
+	"us-gov-west-1",
+}
+
+func login() {
+
+	// prompt user for a url
+	// Prompt user to select a profile
+	prompt1 := promptui.Prompt{
+		Label:   "SSO URL",
+		Default: "",
+	}
+
+	resultSSOUrl, err := prompt1.Run()
+
+	if err != nil {
+		fmt.Printf("Prompt failed %v\n", err)
+		return
+	}
+
+	// Prompt user to select the region of IAM Identity Center, proposing the one given by --region
+	cursor := slices.Index(awsRegions, region)
+	if cursor < 0 {
+		cursor = 14
+	}
+	prompt := promptui.Select{
+		Label:     "Please provide an AWS region.",
+		Items:     awsRegions,
+		CursorPos: cursor,
+	}
+	_, resultRegion, err := prompt.Run()
+	if err != nil {
+		log.Fatalf("prompt failed %v", err)
+	}
+
+	var (
+		startURL  string = "https://" + resultSSOUrl + ".awsapps.com/start"
+		ssoRegion        = resultRegion
+	)
+
+	// the SDKs find the cached token by the name of the sso-session
+	sessionPrompt := promptui.Prompt{
+		Label:   "SSO session name",
+		Default: resultSSOUrl,
+	}
+	sessionName, err := sessionPrompt.Run()
+	if err != nil {
+		log.Fatalf("prompt failed %v", err)
+	}
+
+	ctx, stop := ssmclient.WithInterrupt(context.Background())
+	defer stop()
+
+	// the login needs no credentials, and a stale AWS_PROFILE would keep the config from loading. IAM Identity
+	// Center is called in its own region, which can differ from the one of the environment.
+	os.Unsetenv("AWS_PROFILE")
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(ssoRegion))
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	flow := deviceAuthFlow{
+		client:  ssooidc.NewFromConfig(cfg),
+		openURL: browser.OpenURL,
+	}
+	register, token, err := flow.login(ctx, startURL)
+	if err != nil {
+		exitOnInterrupt(err)
+		if errors.Is(err, ErrAuthorizationDenied) || errors.Is(err, ErrDeviceCodeExpired) {
+			log.Fatalf("%v, please run \"terra3 login\" again", err)
+		}
+		log.Fatal(err)
+	}
+	stop()
+
+	// keep the session for other tools, both for profiles using the sso-session and for those configured with
+	// the start URL only
+	cachedToken := ssoCachedToken{
+		StartURL:     startURL,
+		Region:       ssoRegion,
+		AccessToken:  aws.ToString(token.AccessToken),
+		ExpiresAt:    time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+		RefreshToken: aws.ToString(token.RefreshToken),
+		ClientID:     aws.ToString(register.ClientId),
+		ClientSecret: aws.ToString(register.ClientSecret),
+	}
+	if register.ClientSecretExpiresAt > 0 {
+		registrationExpiresAt := time.Unix(register.ClientSecretExpiresAt, 0)
+		cachedToken.RegistrationExpiresAt = &registrationExpiresAt
+	}
+	err = writeSSOTokenCache(cachedToken, sessionName, startURL)
+	if err != nil {
+		log.Fatalf("unable to cache SSO token, %v", err)
+	}
+
+	ssoClient := sso.NewFromConfig(cfg)
+	accessToken := aws.ToString(token.AccessToken)
+
+	log.Println("Fetching list of accounts for this user")
+	var accounts []ssotypes.AccountInfo
+	accountPaginator := sso.NewListAccountsPaginator(ssoClient, &sso.ListAccountsInput{
+		AccessToken: token.AccessToken,
+	})
+
+	for accountPaginator.HasMorePages() {
+		x, err := accountPaginator.NextPage(context.TODO())
+		if err != nil {
+			log.Fatal(err)
+		}
+		accounts = append(accounts, x.AccountList...)
+	}
+
+	account, err := selectSSOAccount(accounts)
+	if err != nil {
+		log.Fatalf("unable to select account, %v", err)
+	}
+
+	role, err := selectSSORole(ssoClient, accessToken, account)
+	if err != nil {
+		log.Fatalf("unable to select role, %v", err)
+	}
+
+	creds, err := ssoClient.GetRoleCredentials(context.TODO(), &sso.GetRoleCredentialsInput{
+		AccessToken: token.AccessToken,
+		AccountId:   account.AccountId,
+		RoleName:    role.RoleName,
+	})
+	if err != nil {
+		log.Fatalf("unable to get role credentials, %v", err)
+	}
+
+	profileName := loginProfileName
+	if profileName == "" {
+		profileName = ssoProfileName(aws.ToString(account.AccountName), aws.ToString(role.RoleName))
+	}
+	fmt.Printf("Logged in to account %s (%s) as %s.\n", aws.ToString(account.AccountName), aws.ToString(account.AccountId), aws.ToString(role.RoleName))
+
+	actions := []string{
+		"Print the credentials as environment variables",
+		fmt.Sprintf("Write profile %s to the AWS config", profileName),
+		"Start a port-forward to the database",
+		fmt.Sprintf("Write profiles for all %d accounts to the AWS config", len(accounts)),
+		"Done",
+	}
+	actionPrompt := promptui.Select{
+		Label: "What would you like to do next?",
+		Items: actions,
+	}
+	idx, _, err := actionPrompt.Run()
+	if err != nil {
+		log.Fatalf("prompt failed %v", err)
+	}
+
+	switch idx {
+	case 0:
+		printCredentialsEnv(creds.RoleCredentials, ssoRegion)
+	case 1:
+		section := ssoProfileSection(profileName, sessionName, aws.ToString(account.AccountId), aws.ToString(role.RoleName), ssoRegion)
+		writeSSOConfig([]awsConfigSection{ssoSessionSection(sessionName, startURL, ssoRegion), section})
+	case 2:
+		if region == "" {
+			region = ssoRegion
+		}
+		err := useAWSCredentials(aws.Credentials{
+			AccessKeyID:     aws.ToString(creds.RoleCredentials.AccessKeyId),
+			SecretAccessKey: aws.ToString(creds.RoleCredentials.SecretAccessKey),
+			SessionToken:    aws.ToString(creds.RoleCredentials.SessionToken),
+			Source:          "terra3 login",
+			CanExpire:       true,
+			Expires:         time.UnixMilli(creds.RoleCredentials.Expiration),
+		})
+		if err != nil {
+			log.Fatalf("unable to load SDK config, %v", err)
+		}
+		localPort = -1
+		dbPortForwardToDB()
+	case 3:
+		sections, err := ssoConfigSections(ssoClient, accessToken, sessionName, startURL, ssoRegion, accounts)
+		if err != nil {
+			log.Fatalf("unable to list account roles, %v", err)
+		}
+		writeSSOConfig(sections)
+	}
+}
+
+// selectSSOAccount lets the user select one of the accounts available through SSO.
+func selectSSOAccount(accounts []ssotypes.AccountInfo) (ssotypes.AccountInfo, error) {
+	items := make([]string, len(accounts))
+	for i, account := range accounts {
+		items[i] = fmt.Sprintf("%-30s | %s | %s", aws.ToString(account.AccountName), aws.ToString(account.AccountId), aws.ToString(account.EmailAddress))
+	}
+
+	idx, err := selectOne("Select AWS account", items, "an account")
+	if err != nil {
+		return ssotypes.AccountInfo{}, err
+	}
+	return accounts[idx], nil
+}
+
+// selectSSORole lets the user select one of the roles available in the account.
+func selectSSORole(client *sso.Client, accessToken string, account ssotypes.AccountInfo) (ssotypes.RoleInfo, error) {
+	var roles []ssotypes.RoleInfo
+	paginator := sso.NewListAccountRolesPaginator(client, &sso.ListAccountRolesInput{
+		AccessToken: aws.String(accessToken),
+		AccountId:   account.AccountId,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return ssotypes.RoleInfo{}, err
+		}
+		roles = append(roles, page.RoleList...)
+	}
+
+	items := make([]string, len(roles))
+	for i, role := range roles {
+		items[i] = aws.ToString(role.RoleName)
+	}
+
+	idx, err := selectOne("Select role", items, "a role")
+	if err != nil {
+		return ssotypes.RoleInfo{}, err
+	}
+	return roles[idx], nil
+}
+
+// printCredentialsEnv prints the commands setting the credentials as environment variables, for PowerShell on
+// Windows and for POSIX shells elsewhere.
+func printCredentialsEnv(creds *ssotypes.RoleCredentials, ssoRegion string) {
+	vars := [][2]string{
+		{"AWS_ACCESS_KEY_ID", aws.ToString(creds.AccessKeyId)},
+		{"AWS_SECRET_ACCESS_KEY", aws.ToString(creds.SecretAccessKey)},
+		{"AWS_SESSION_TOKEN", aws.ToString(creds.SessionToken)},
+		{"AWS_REGION", ssoRegion},
+	}
+	for _, v := range vars {
+		if runtime.GOOS == "windows" {
+			fmt.Printf("$Env:%s=\"%s\"\n", v[0], v[1])
+		} else {
+			fmt.Printf("export %s=%s\n", v[0], shellQuote(v[1]))
+		}
+	}
+	expires := time.UnixMilli(creds.Expiration).Local().Format("2006-01-02 15:04:05")
+	fmt.Printf("# The credentials expire at %s.\n", expires)
+}
+
+// writeSSOConfig writes the sections to the AWS config and lists the profiles written.
+func writeSSOConfig(sections []awsConfigSection) {
+	path, err := writeAWSConfigSections(sections)
+	if err != nil {
+		log.Fatalf("unable to write AWS config, %v", err)
+	}
+
+	fmt.Printf("Wrote the following profiles to %s:\n", path)
+	for _, section := range sections {
+		if name, ok := strings.CutPrefix(section.Header, "profile "); ok {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+	fmt.Println("Use them e.g. with \"terra3 db port-forward --profile <profile>\".")
+}
+
+const ssoRegistrationScope = "sso:account:access"
+
+// ssoConfigSections returns the [sso-session] section for the login and a [profile] section for each role the user
+// has in the accounts. Profiles are named <account name>-<role name>.
+func ssoConfigSections(client *sso.Client, accessToken string, sessionName string, startURL string, ssoRegion string, accounts []ssotypes.AccountInfo) ([]awsConfigSection, error) {
+	sections := []awsConfigSection{ssoSessionSection(sessionName, startURL, ssoRegion)}
+
+	for _, account := range accounts {
+		paginator := sso.NewListAccountRolesPaginator(client, &sso.ListAccountRolesInput{
+			AccessToken: aws.String(accessToken),
+			AccountId:   account.AccountId,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return nil, err
+			}
+			for _, role := range page.RoleList {
+				name := ssoProfileName(aws.ToString(account.AccountName), aws.ToString(role.RoleName))
+				sections = append(sections, ssoProfileSection(name, sessionName, aws.ToString(role.AccountId), aws.ToString(role.RoleName), ssoRegion))
+			}
+		}
+	}
+	return sections, nil
+}
+
+func ssoSessionSection(sessionName string, startURL string, ssoRegion string) awsConfigSection {
+	return awsConfigSection{
+		Header: "sso-session " + sessionName,
+		Keys: [][2]string{
+			{"sso_start_url", startURL},
+			{"sso_region", ssoRegion},
+			{"sso_registration_scopes", ssoRegistrationScope},
+		},
+	}
+}
+
+func ssoProfileSection(name string, sessionName string, accountID string, roleName string, region string) awsConfigSection {
+	return awsConfigSection{
+		Header: "profile " + name,
+		Keys: [][2]string{
+			{"sso_session", sessionName},
+			{"sso_account_id", accountID},
+			{"sso_role_name", roleName},
+			{"region", region},
+		},
+	}
+}
+
+// ssoProfileName builds a profile name without spaces or other characters that are awkward in a shell.
+func ssoProfileName(accountName string, roleName string) string {
+	name := strings.ToLower(accountName + "-" + roleName)
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '-'
+	}, name)
+}
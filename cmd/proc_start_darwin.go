@@ -0,0 +1,20 @@
+//go:build darwin
+
+package cmd
+
+import (
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// processStartID returns the start time of the process, which tells the process apart from a later one that got
+// the same pid.
+func processStartID(pid int) (string, error) {
+	info, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if err != nil {
+		return "", err
+	}
+	start := info.Proc.P_starttime
+	return strconv.FormatInt(start.Sec, 10) + "." + strconv.FormatInt(int64(start.Usec), 10), nil
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processStartID returns the start time of the process in clock ticks after boot, which tells the process apart
+// from a later one that got the same pid.
+func processStartID(pid int) (string, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return "", err
+	}
+
+	// the command name in parentheses may contain spaces, so the fields are counted after its end
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return "", errors.New("unexpected format of /proc/<pid>/stat")
+	}
+	fields := strings.Fields(stat[end+1:])
+	// starttime is field 22, the state is field 3 and the first one after the command name
+	if len(fields) < 20 {
+		return "", errors.New("unexpected format of /proc/<pid>/stat")
+	}
+	return fields[19], nil
+}
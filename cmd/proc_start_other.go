@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package cmd
+
+// processStartID is not available on this platform, so processes are told apart by their pid only.
+func processStartID(pid int) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolateProcess starts cmd in its own process group, so that pressing Ctrl-C in the terminal is not forwarded to it.
+func isolateProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// stopProcess asks the process to shut down, giving it the chance to terminate its SSM session.
+func stopProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
+
+// detachProcess starts cmd in a new session, so that it keeps running after the terminal is closed.
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// processAlive reports whether a process with the given pid is running.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// signal 0 performs the existence and permission checks without sending a signal
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,68 @@
+//go:build windows
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// isolateProcess starts cmd in its own process group, so that pressing Ctrl-C in the terminal is not forwarded to it.
+func isolateProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// stopProcess terminates the process together with its child processes, which Windows does not terminate with
+// their parent. Otherwise the "tunnel run" child of a supervising process would keep the local port open. Windows
+// has no equivalent of SIGTERM for console processes in another process group, so the SSM session is left to time
+// out.
+func stopProcess(p *os.Process) error {
+	taskkill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid))
+	if err := taskkill.Run(); err != nil {
+		// taskkill is missing or failed, at least the process itself is terminated
+		return p.Kill()
+	}
+	return nil
+}
+
+// detachedProcess is the process creation flag for console processes without a console.
+const detachedProcess = 0x00000008
+
+// detachProcess starts cmd without a console, so that it keeps running after the terminal is closed.
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess}
+}
+
+// processAlive reports whether a process with the given pid is running.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	// STILL_ACTIVE
+	return code == 259
+}
+
+// processStartID returns the creation time of the process, which tells the process apart from a later one that got
+// the same pid.
+func processStartID(pid int) (string, error) {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(h)
+
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(creation.Nanoseconds(), 10), nil
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// projectConfigFileName is the name of the project configuration file, searched upward from the working directory.
+const projectConfigFileName = ".terra3.yaml"
+
+// projectConfig is the content of a .terra3.yaml file, declaring the environments of a project:
+//
+//	default_env: staging
+//	environments:
+//	  staging:
+//	    profile: acme-staging
+//	    region: eu-central-1
+//	    tag: environment=staging
+//	    bastion_tag: Name=acme-staging-bastion
+//	    db_identifier: acme-staging-db
+//	    local_port: 15432
+type projectConfig struct {
+	DefaultEnv   string                        `yaml:"default_env"`
+	Environments map[string]projectEnvironment `yaml:"environments"`
+}
+
+type projectEnvironment struct {
+	Profile      string `yaml:"profile"`
+	Region       string `yaml:"region"`
+	Tag          string `yaml:"tag"`
+	BastionTag   string `yaml:"bastion_tag"`
+	DBIdentifier string `yaml:"db_identifier"`
+	// LocalPort is a pointer, so local_port: 0 can ask for a free port
+	LocalPort *int `yaml:"local_port"`
+}
+
+// projectSetting is a value that can be given as flag, as one of the environment variables or in the environment of
+// the project configuration file, in this order of precedence. Commands listed in except are left alone.
+type projectSetting struct {
+	flag    string
+	envVars []string
+	value   func(env projectEnvironment) string
+	except  []*cobra.Command
+}
+
+var projectSettings = []projectSetting{
+	// an exported AWS_PROFILE is as explicit as TERRA3_PROFILE; login creates profiles instead of using one
+	{"profile", []string{"TERRA3_PROFILE", "AWS_PROFILE"}, func(env projectEnvironment) string { return env.Profile }, []*cobra.Command{loginCmd}},
+	// the credentials are fetched from the region of the login, not the one of the workload
+	{"region", []string{"TERRA3_REGION"}, func(env projectEnvironment) string { return env.Region }, []*cobra.Command{credentialsCmd}},
+	{"tag", []string{"TERRA3_TAG"}, func(env projectEnvironment) string { return env.Tag }, nil},
+	{"bastion-tag", []string{"TERRA3_BASTION_TAG"}, func(env projectEnvironment) string { return env.BastionTag }, nil},
+	{"db-identifier", []string{"TERRA3_DB_IDENTIFIER"}, func(env projectEnvironment) string { return env.DBIdentifier }, nil},
+	{"local-port", []string{"TERRA3_LOCAL_PORT"}, func(env projectEnvironment) string {
+		if env.LocalPort == nil {
+			return ""
+		}
+		return strconv.Itoa(*env.LocalPort)
+	}, nil},
+}
+
+// projectConfigOptional lists the commands which need no AWS context. A broken project configuration does not keep
+// them from running.
+var projectConfigOptional = []*cobra.Command{versionCmd, tunnelListCmd, tunnelStopCmd}
+
+// needsProjectConfig reports whether the command fails on a broken project configuration. Besides the commands in
+// projectConfigOptional, the help and completion commands of cobra need none.
+func needsProjectConfig(cmd *cobra.Command) bool {
+	if slices.Contains(projectConfigOptional, cmd) || cmd.Name() == "help" {
+		return false
+	}
+	return !cmd.HasParent() || cmd.Parent().Name() != "completion"
+}
+
+var envName string
+
+// applyProjectConfig fills the flags of the command which are not given on the command line from the environment
+// variables, or else from the environment selected with --env, TERRA3_ENV or default_env of the project
+// configuration file. Flags the command does not have are skipped.
+func applyProjectConfig(cmd *cobra.Command) error {
+	env, err := selectProjectEnvironment(cmd)
+	if err != nil {
+		return err
+	}
+
+	for _, setting := range projectSettings {
+		flag := cmd.Flags().Lookup(setting.flag)
+		if flag == nil || flag.Changed || slices.Contains(setting.except, cmd) {
+			continue
+		}
+
+		var value, source string
+		for _, envVar := range setting.envVars {
+			if value = os.Getenv(envVar); value != "" {
+				source = envVar
+				break
+			}
+		}
+		if value == "" {
+			value = setting.value(env)
+			source = "the project configuration"
+		}
+		if value == "" {
+			continue
+		}
+
+		// setting the flag marks it as given, so the commands treat the value like one from the command line
+		if err := cmd.Flags().Set(setting.flag, value); err != nil {
+			return fmt.Errorf("invalid value %q for --%s from %s, %w", value, setting.flag, source, err)
+		}
+	}
+	return nil
+}
+
+// selectProjectEnvironment returns the environment selected with --env, TERRA3_ENV or default_env. Without a
+// selection, or without a project configuration file, the environment is empty. Only an environment given with
+// --env requires a project configuration file; TERRA3_ENV may be set globally and is ignored outside of projects.
+func selectProjectEnvironment(cmd *cobra.Command) (projectEnvironment, error) {
+	name := envName
+	explicit := cmd.Flags().Changed("env")
+	if !explicit {
+		name = os.Getenv("TERRA3_ENV")
+	}
+
+	path, err := findProjectConfig()
+	if err != nil {
+		return projectEnvironment{}, err
+	}
+	if path == "" {
+		if explicit {
+			return projectEnvironment{}, fmt.Errorf("environment %s selected, but no %s found", name, projectConfigFileName)
+		}
+		return projectEnvironment{}, nil
+	}
+
+	config, err := loadProjectConfig(path)
+	if err != nil {
+		return projectEnvironment{}, err
+	}
+
+	if name == "" {
+		name = config.DefaultEnv
+	}
+	if name == "" {
+		return projectEnvironment{}, nil
+	}
+
+	env, ok := config.Environments[name]
+	if !ok {
+		names := make([]string, 0, len(config.Environments))
+		for n := range config.Environments {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return projectEnvironment{}, fmt.Errorf("environment %s not found in %s, available: %s", name, path, strings.Join(names, ", "))
+	}
+	return env, nil
+}
+
+// findProjectConfig returns the path of the project configuration file. TERRA3_CONFIG takes precedence, then
+// .terra3.yaml is searched from the working directory upward, and finally ~/.config/terra3/config.yaml is used.
+// If there is none, the path is empty.
+func findProjectConfig() (string, error) {
+	if path := os.Getenv("TERRA3_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, projectConfigFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		// without a home directory, there is no user configuration
+		return "", nil
+	}
+	path := filepath.Join(home, ".config", "terra3", "config.yaml")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	return "", nil
+}
+
+// loadProjectConfig reads the project configuration file. Unknown keys are rejected, so typos do not go unnoticed.
+func loadProjectConfig(path string) (projectConfig, error) {
+	var config projectConfig
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return config, fmt.Errorf("unable to parse %s, %w", path, err)
+	}
+	return config, nil
+}
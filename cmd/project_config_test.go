@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+const testProjectConfig = `default_env: staging
+environments:
+  staging:
+    profile: acme-staging
+    region: eu-central-1
+    local_port: 15432
+  prod:
+    profile: acme-prod
+    region: us-east-1
+    local_port: 0
+`
+
+// setupProjectConfig runs the test in a subdirectory of a project with the configuration, without any of the
+// environment variables taking part.
+func setupProjectConfig(t *testing.T, content string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, name := range []string{"TERRA3_CONFIG", "TERRA3_ENV", "TERRA3_PROFILE", "AWS_PROFILE", "TERRA3_REGION", "TERRA3_LOCAL_PORT"} {
+		t.Setenv(name, "")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	if content != "" {
+		if err := os.WriteFile(filepath.Join(root, projectConfigFileName), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := filepath.Join(root, "services", "api")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	return root
+}
+
+// newProjectConfigTestCommand returns a command with some of the project settings as flags, parsed from args.
+func newProjectConfigTestCommand(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().String("profile", "", "")
+	cmd.Flags().String("region", "", "")
+	cmd.Flags().Int("local-port", 5432, "")
+	cmd.Flags().StringVar(&envName, "env", "", "")
+	t.Cleanup(func() { envName = "" })
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestApplyProjectConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		profile string
+		region  string
+		port    string
+	}{
+		{"default environment", nil, nil, "acme-staging", "eu-central-1", "15432"},
+		{"env flag", []string{"--env", "prod"}, nil, "acme-prod", "us-east-1", "0"},
+		{"env variable", nil, map[string]string{"TERRA3_ENV": "prod"}, "acme-prod", "us-east-1", "0"},
+		{"env flag before variable", []string{"--env", "staging"}, map[string]string{"TERRA3_ENV": "prod"}, "acme-staging", "eu-central-1", "15432"},
+		{"variables before file", nil, map[string]string{"TERRA3_PROFILE": "other", "TERRA3_LOCAL_PORT": "6543"}, "other", "eu-central-1", "6543"},
+		{"aws profile before file", nil, map[string]string{"AWS_PROFILE": "exported"}, "exported", "eu-central-1", "15432"},
+		{"terra3 profile before aws profile", nil, map[string]string{"TERRA3_PROFILE": "other", "AWS_PROFILE": "exported"}, "other", "eu-central-1", "15432"},
+		{"flags before variables", []string{"--profile", "flag", "--local-port", "7654"}, map[string]string{"TERRA3_PROFILE": "other", "TERRA3_REGION": "ap-south-1"}, "flag", "ap-south-1", "7654"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupProjectConfig(t, testProjectConfig)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cmd := newProjectConfigTestCommand(t, tt.args...)
+
+			if err := applyProjectConfig(cmd); err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			for flag, want := range map[string]string{"profile": tt.profile, "region": tt.region, "local-port": tt.port} {
+				if got := cmd.Flags().Lookup(flag).Value.String(); got != want {
+					t.Errorf("--%s = %q, want %q", flag, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyProjectConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		args    []string
+		env     map[string]string
+	}{
+		{"unknown environment flag", testProjectConfig, []string{"--env", "dev"}, nil},
+		{"unknown environment variable", testProjectConfig, nil, map[string]string{"TERRA3_ENV": "dev"}},
+		{"unknown key", "environments:\n  staging:\n    profil: acme-staging\n", nil, nil},
+		{"malformed file", "environments: [staging\n", nil, nil},
+		{"env flag without file", "", []string{"--env", "staging"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupProjectConfig(t, tt.content)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cmd := newProjectConfigTestCommand(t, tt.args...)
+
+			if err := applyProjectConfig(cmd); err == nil {
+				t.Error("no error")
+			}
+			if cmd.Flags().Changed("profile") {
+				t.Error("profile set despite the error")
+			}
+		})
+	}
+}
+
+func TestApplyProjectConfigWithoutFile(t *testing.T) {
+	setupProjectConfig(t, "")
+	// a globally set TERRA3_ENV does not matter outside of projects
+	t.Setenv("TERRA3_ENV", "prod")
+	cmd := newProjectConfigTestCommand(t)
+
+	if err := applyProjectConfig(cmd); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if cmd.Flags().Changed("profile") || cmd.Flags().Changed("local-port") {
+		t.Error("flags set without a project configuration")
+	}
+}
+
+func TestFindProjectConfig(t *testing.T) {
+	root := setupProjectConfig(t, testProjectConfig)
+	project := filepath.Join(root, projectConfigFileName)
+
+	// the search goes upward from the working directory
+	path, err := findProjectConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameFile(t, path, project) {
+		t.Errorf("path = %q, want %q", path, project)
+	}
+
+	// TERRA3_CONFIG takes precedence
+	explicit := filepath.Join(t.TempDir(), "terra3.yaml")
+	t.Setenv("TERRA3_CONFIG", explicit)
+	if path, _ := findProjectConfig(); path != explicit {
+		t.Errorf("path = %q, want %q", path, explicit)
+	}
+	t.Setenv("TERRA3_CONFIG", "")
+
+	// outside of projects, the user configuration is used
+	if err := os.Remove(project); err != nil {
+		t.Fatal(err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := filepath.Join(home, ".config", "terra3", "config.yaml")
+	if err := os.MkdirAll(filepath.Dir(user), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(user, []byte(testProjectConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if path, _ := findProjectConfig(); path != user {
+		t.Errorf("path = %q, want %q", path, user)
+	}
+}
+
+func TestNeedsProjectConfig(t *testing.T) {
+	for _, cmd := range []*cobra.Command{versionCmd, tunnelListCmd, tunnelStopCmd} {
+		if needsProjectConfig(cmd) {
+			t.Errorf("%s needs the project configuration", cmd.CommandPath())
+		}
+	}
+	for _, cmd := range []*cobra.Command{dbPortForwardCmd, whoamiCmd} {
+		if !needsProjectConfig(cmd) {
+			t.Errorf("%s does not need the project configuration", cmd.CommandPath())
+		}
+	}
+}
+
+// sameFile compares paths of existing files, which may differ in symlinks of the temporary directory.
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
@@ -4,6 +4,7 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"log"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -21,10 +22,17 @@ var rootCmd = &cobra.Command{
 	The Terra3 CLI provides features to manage Terra3 stacks from the command line. It can
 	
 	* create a secure port-forward to the private RDS database using SSM 
+	* open a shell on the bastion host using SSM
 	* manage environment hibernation (start/stop/status)
 	* manage AWS secrets related to the environment
 	* comfortably shelling into a container (if ECS exec is activated for the cluster)
 	* and much more to come! 
+
+	Profile, region, tag, bastion host, database and local port can be declared per environment in a
+	.terra3.yaml file, which is searched from the current directory upward and then in
+	~/.config/terra3/config.yaml. Select an environment with --env or TERRA3_ENV. Flags take precedence over
+	environment variables (TERRA3_PROFILE or AWS_PROFILE, TERRA3_REGION, TERRA3_TAG, TERRA3_BASTION_TAG,
+	TERRA3_DB_IDENTIFIER, TERRA3_LOCAL_PORT), which take precedence over the file.
 	`,
 }
 
@@ -38,6 +46,23 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		// internal commands get all values from the command that started them
+		if cmd.Hidden {
+			return
+		}
+		if err := applyProjectConfig(cmd); err != nil {
+			if !needsProjectConfig(cmd) {
+				log.Printf("Warning: %v", err)
+				return
+			}
+			log.Fatal(err)
+		}
+	}
 	rootCmd.AddCommand(dbCmd)
 	rootCmd.AddCommand(loginCmd)
+	rootCmd.PersistentFlags().StringVarP(&profile, "profile", "p", "", "Optional AWS profile to use. If not provided, a selection menu will open.")
+	rootCmd.PersistentFlags().StringVarP(&region, "region", "r", "", "Optional AWS region to use. Defaults to the region of the profile or environment.")
+	rootCmd.PersistentFlags().StringVar(&envName, "env", "", "Optional environment of the project configuration file (.terra3.yaml) providing defaults for profile, region, bastion host, database and local port.")
+	rootCmd.PersistentFlags().StringVar(&profileFilter, "profile-filter", "", "Optional pattern limiting the AWS profiles to select from, e.g. \"staging\" or \"*-admin\". A single match is used right away.")
 }
@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+// secretsCmd represents the secrets command
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage the secrets of your environment.",
+	Long: `Manage the secrets of your environment, stored in Secrets Manager or as SecureString parameters in Parameter
+	Store. The secrets of the environment are selected by a name prefix with --prefix, a tag with --tag key=value,
+	or both. Values are masked unless --reveal is given. Use one of the sub-commands.
+	* list: List the secrets of the environment.
+	* get: Show a secret.
+	* set: Create or update a secret.
+	* delete: Delete a secret.
+	* rotate: Rotate a secret using its Secrets Manager rotation.
+	`,
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the secrets of the environment.",
+	Long:  `List the secrets of the environment from Secrets Manager and Parameter Store.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		secretsList()
+	},
+}
+
+var secretsGetCmd = &cobra.Command{
+	Use:   "get <name>",
+	Short: "Show a secret of the environment.",
+	Long:  `Show a secret of the environment. The name may be given without the prefix.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		secretsGet(args[0])
+	},
+}
+
+var secretsSetCmd = &cobra.Command{
+	Use:   "set <name> [value]",
+	Short: "Create or update a secret of the environment.",
+	Long: `Create or update a secret of the environment. If the value is not given, it is read from stdin, or
+	prompted for without echo if stdin is a terminal. New secrets are created in Secrets Manager unless
+	--store parameterstore is given, and get the tag given with --tag.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		secretsSet(args)
+	},
+}
+
+var secretsDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a secret of the environment.",
+	Long: `Delete a secret of the environment. Secrets Manager secrets can be restored within the recovery window
+	of 30 days, unless --force is given. Parameters are deleted immediately.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		secretsDelete(args[0])
+	},
+}
+
+var secretsRotateCmd = &cobra.Command{
+	Use:   "rotate <name>",
+	Short: "Rotate a secret of the environment.",
+	Long: `Rotate a Secrets Manager secret of the environment immediately, using the rotation configured for it.
+	Parameters have no rotation, change them with "terra3 secrets set" instead.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		secretsRotate(args[0])
+	},
+}
+
+var (
+	secretsPrefix string
+	secretsStore  string
+	reveal        bool
+	forceDelete   bool
+)
+
+func init() {
+	rootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsListCmd)
+	secretsCmd.AddCommand(secretsGetCmd)
+	secretsCmd.AddCommand(secretsSetCmd)
+	secretsCmd.AddCommand(secretsDeleteCmd)
+	secretsCmd.AddCommand(secretsRotateCmd)
+	secretsCmd.PersistentFlags().StringVar(&secretsPrefix, "prefix", "", "Name prefix of the secrets of the environment, e.g. /staging/.")
+	secretsCmd.PersistentFlags().StringVarP(&envTag, "tag", "t", "", "Tag of the secrets of the environment, as key=value.")
+	secretsCmd.PersistentFlags().StringVar(&secretsStore, "store", "", "Optional store to use: secretsmanager or parameterstore. Defaults to the store holding the secret, or Secrets Manager for new secrets.")
+	secretsListCmd.Flags().BoolVar(&reveal, "reveal", false, "Show the values instead of masking them.")
+	secretsGetCmd.Flags().BoolVar(&reveal, "reveal", false, "Show the value instead of masking it.")
+	secretsDeleteCmd.Flags().BoolVar(&forceDelete, "force", false, "Delete Secrets Manager secrets without recovery window.")
+	secretsDeleteCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Delete without asking for confirmation.")
+}
+
+// secretsScope is the set of secrets belonging to the environment.
+type secretsScope struct {
+	cfg      aws.Config
+	prefix   string
+	tagKey   string
+	tagValue string
+}
+
+// secretsSetup selects the profile, loads the SDK config and validates the scope given by --prefix and --tag.
+func secretsSetup() secretsScope {
+	scope := secretsScope{prefix: secretsPrefix}
+	if envTag != "" {
+		var err error
+		if scope.tagKey, scope.tagValue, err = parseTag("--tag", envTag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if scope.prefix == "" && scope.tagKey == "" {
+		log.Fatalf("please select the secrets of the environment with --prefix, --tag or both")
+	}
+	if secretsStore != "" && secretsStore != storeSecretsManager && secretsStore != storeParameterStore {
+		log.Fatalf("invalid store %q, please use %s or %s", secretsStore, storeSecretsManager, storeParameterStore)
+	}
+
+	selectProfile()
+
+	var err error
+	scope.cfg, err = loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+	return scope
+}
+
+// name returns the full name of a secret, adding the prefix if it is missing.
+func (s secretsScope) name(name string) string {
+	if strings.HasPrefix(name, s.prefix) {
+		return name
+	}
+	return s.prefix + name
+}
+
+// find looks the secret up in the stores. It returns nil if the secret does not exist, and fails if it exists in
+// both stores without --store choosing one, or if it does not belong to the environment.
+func (s secretsScope) find(name string) *secretEntry {
+	var found []*secretEntry
+
+	if secretsStore != storeParameterStore {
+		entry, err := describeManagedSecret(secretsmanager.NewFromConfig(s.cfg), name)
+		if err != nil {
+			log.Fatalf("unable to look up secret %s, %v", name, err)
+		}
+		if entry != nil {
+			found = append(found, entry)
+		}
+	}
+	if secretsStore != storeSecretsManager {
+		entry, err := describeParameter(ssm.NewFromConfig(s.cfg), name)
+		if err != nil {
+			log.Fatalf("unable to look up parameter %s, %v", name, err)
+		}
+		if entry != nil {
+			found = append(found, entry)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil
+	case 2:
+		log.Fatalf("%s exists in Secrets Manager and Parameter Store, please choose one with --store", name)
+	}
+
+	if !found[0].inScope(s.prefix, s.tagKey, s.tagValue) {
+		log.Fatalf("%s does not belong to the environment, it is not tagged %s=%s", name, s.tagKey, s.tagValue)
+	}
+	return found[0]
+}
+
+// mustFind is find for secrets that have to exist.
+func (s secretsScope) mustFind(name string) secretEntry {
+	entry := s.find(name)
+	if entry == nil {
+		log.Fatalf("secret %s not found", name)
+	}
+	return *entry
+}
+
+// maskedValue returns the value if --reveal is given, and a mask otherwise.
+func maskedValue(value string) string {
+	if reveal {
+		return value
+	}
+	return "********"
+}
+
+func secretsList() {
+	scope := secretsSetup()
+
+	var entries []secretEntry
+	if secretsStore != storeParameterStore {
+		secrets, err := listManagedSecrets(secretsmanager.NewFromConfig(scope.cfg), scope.prefix, scope.tagKey, scope.tagValue)
+		if err != nil {
+			log.Fatalf("unable to list secrets, %v", err)
+		}
+		entries = append(entries, secrets...)
+	}
+	if secretsStore != storeSecretsManager {
+		parameters, err := listParameters(ssm.NewFromConfig(scope.cfg), scope.prefix, scope.tagKey, scope.tagValue)
+		if err != nil {
+			log.Fatalf("unable to list parameters, %v", err)
+		}
+		entries = append(entries, parameters...)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No secrets found.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STORE\tNAME\tLAST CHANGED\tVALUE")
+	for _, entry := range entries {
+		value := maskedValue("")
+		if reveal {
+			var err error
+			if value, err = secretValue(scope.cfg, entry); err != nil {
+				value = fmt.Sprintf("<%v>", err)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Store, entry.Name, entry.LastChanged.Local().Format("2006-01-02 15:04"), value)
+	}
+	w.Flush()
+}
+
+func secretsGet(name string) {
+	scope := secretsSetup()
+	entry := scope.mustFind(scope.name(name))
+
+	value := maskedValue("")
+	if reveal {
+		var err error
+		if value, err = secretValue(scope.cfg, entry); err != nil {
+			log.Fatalf("unable to get value of %s, %v", entry.Name, err)
+		}
+		// the value alone, so it can be used in scripts
+		fmt.Println(value)
+		return
+	}
+
+	fmt.Printf("%s (%s, last changed %s). Use --reveal to show it.\n", entry.Name, entry.Store, entry.LastChanged.Local().Format("2006-01-02 15:04"))
+}
+
+func secretsSet(args []string) {
+	scope := secretsSetup()
+	name := scope.name(args[0])
+
+	var value string
+	if len(args) == 2 {
+		value = args[1]
+	} else {
+		value = readSecretValue()
+	}
+
+	store := secretsStore
+	entry := scope.find(name)
+	if entry != nil {
+		store = entry.Store
+	} else if store == "" {
+		store = storeSecretsManager
+	}
+
+	if err := putSecretValue(scope.cfg, store, name, value, entry != nil, scope.tagKey, scope.tagValue); err != nil {
+		log.Fatalf("unable to set %s, %v", name, err)
+	}
+
+	if entry != nil {
+		fmt.Printf("Updated %s in %s.\n", name, store)
+	} else {
+		fmt.Printf("Created %s in %s.\n", name, store)
+	}
+}
+
+// readSecretValue prompts for the value without echo if stdin is a terminal, and reads it from stdin otherwise.
+func readSecretValue() string {
+	if isInteractive() {
+		prompt := promptui.Prompt{
+			Label: "Value",
+			Mask:  '*',
+		}
+		value, err := prompt.Run()
+		if err != nil {
+			log.Fatalf("prompt failed %v", err)
+		}
+		return value
+	}
+
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatalf("unable to read value from stdin, %v", err)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+}
+
+func secretsDelete(name string) {
+	scope := secretsSetup()
+	entry := scope.mustFind(scope.name(name))
+
+	if !assumeYes {
+		if !isInteractive() {
+			log.Fatalf("stdin is not a terminal, please confirm with --yes")
+		}
+		prompt := promptui.Prompt{
+			Label:     fmt.Sprintf("Delete %s from %s", entry.Name, entry.Store),
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			log.Fatalf("aborted")
+		}
+	}
+
+	if err := deleteSecret(scope.cfg, entry, forceDelete); err != nil {
+		log.Fatalf("unable to delete %s, %v", entry.Name, err)
+	}
+
+	if entry.Store == storeSecretsManager && !forceDelete {
+		fmt.Printf("Scheduled %s for deletion, it can be restored within 30 days.\n", entry.Name)
+	} else {
+		fmt.Printf("Deleted %s.\n", entry.Name)
+	}
+}
+
+func secretsRotate(name string) {
+	scope := secretsSetup()
+	entry := scope.mustFind(scope.name(name))
+
+	if entry.Store != storeSecretsManager {
+		log.Fatalf("%s is a parameter, which cannot be rotated. Use \"terra3 secrets set\" to change it.", entry.Name)
+	}
+
+	out, err := secretsmanager.NewFromConfig(scope.cfg).RotateSecret(context.TODO(), &secretsmanager.RotateSecretInput{
+		SecretId: aws.String(entry.Name),
+	})
+	if err != nil {
+		log.Fatalf("unable to rotate %s, %v", entry.Name, err)
+	}
+	fmt.Printf("Rotation of %s started, new version %s.\n", entry.Name, aws.ToString(out.VersionId))
+}
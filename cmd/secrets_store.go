@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// Stores secrets are kept in.
+const (
+	storeSecretsManager = "secretsmanager"
+	storeParameterStore = "parameterstore"
+)
+
+// secretEntry is a secret in Secrets Manager or a SecureString parameter in Parameter Store.
+type secretEntry struct {
+	Store       string
+	Name        string
+	LastChanged time.Time
+	Tags        map[string]string
+}
+
+// inScope reports whether the secret belongs to the environment selected by --prefix and --tag.
+func (s secretEntry) inScope(prefix string, tagKey string, tagValue string) bool {
+	if prefix != "" && !strings.HasPrefix(s.Name, prefix) {
+		return false
+	}
+	if tagKey != "" && s.Tags[tagKey] != tagValue {
+		return false
+	}
+	return true
+}
+
+// listManagedSecrets returns the Secrets Manager secrets with the name prefix and tag.
+func listManagedSecrets(client *secretsmanager.Client, prefix string, tagKey string, tagValue string) ([]secretEntry, error) {
+	var filters []smtypes.Filter
+	if prefix != "" {
+		filters = append(filters, smtypes.Filter{Key: smtypes.FilterNameStringTypeName, Values: []string{prefix}})
+	}
+	if tagKey != "" {
+		filters = append(filters, smtypes.Filter{Key: smtypes.FilterNameStringTypeTagKey, Values: []string{tagKey}})
+	}
+
+	var result []secretEntry
+	paginator := secretsmanager.NewListSecretsPaginator(client, &secretsmanager.ListSecretsInput{Filters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range page.SecretList {
+			entry := managedSecretEntry(secret.Name, secret.LastChangedDate, secret.CreatedDate, secret.Tags)
+			// the name filter matches prefixes of words, so the scope is checked exactly
+			if entry.inScope(prefix, tagKey, tagValue) {
+				result = append(result, entry)
+			}
+		}
+	}
+	return result, nil
+}
+
+// describeManagedSecret returns the Secrets Manager secret with the name, or nil if there is none.
+func describeManagedSecret(client *secretsmanager.Client, name string) (*secretEntry, error) {
+	out, err := client.DescribeSecret(context.TODO(), &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	var notFound *smtypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out.DeletedDate != nil {
+		return nil, fmt.Errorf("secret %s is scheduled for deletion", name)
+	}
+
+	entry := managedSecretEntry(out.Name, out.LastChangedDate, out.CreatedDate, out.Tags)
+	return &entry, nil
+}
+
+func managedSecretEntry(name *string, lastChanged *time.Time, created *time.Time, tags []smtypes.Tag) secretEntry {
+	entry := secretEntry{Store: storeSecretsManager, Name: aws.ToString(name), Tags: map[string]string{}}
+	if lastChanged != nil {
+		entry.LastChanged = *lastChanged
+	} else if created != nil {
+		entry.LastChanged = *created
+	}
+	for _, tag := range tags {
+		entry.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return entry
+}
+
+// listParameters returns the SecureString parameters with the name prefix and tag.
+func listParameters(client *ssm.Client, prefix string, tagKey string, tagValue string) ([]secretEntry, error) {
+	filters := []ssmtypes.ParameterStringFilter{
+		{Key: aws.String("Type"), Values: []string{string(ssmtypes.ParameterTypeSecureString)}},
+	}
+	if prefix != "" {
+		filters = append(filters, ssmtypes.ParameterStringFilter{Key: aws.String("Name"), Option: aws.String("BeginsWith"), Values: []string{prefix}})
+	}
+	if tagKey != "" {
+		filters = append(filters, ssmtypes.ParameterStringFilter{Key: aws.String("tag:" + tagKey), Values: []string{tagValue}})
+	}
+
+	var result []secretEntry
+	paginator := ssm.NewDescribeParametersPaginator(client, &ssm.DescribeParametersInput{ParameterFilters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, parameter := range page.Parameters {
+			entry := secretEntry{Store: storeParameterStore, Name: aws.ToString(parameter.Name), Tags: map[string]string{}}
+			if parameter.LastModifiedDate != nil {
+				entry.LastChanged = *parameter.LastModifiedDate
+			}
+			if tagKey != "" {
+				// the parameter was filtered by the tag already
+				entry.Tags[tagKey] = tagValue
+			}
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// describeParameter returns the SecureString parameter with the name, or nil if there is none.
+func describeParameter(client *ssm.Client, name string) (*secretEntry, error) {
+	out, err := client.DescribeParameters(context.TODO(), &ssm.DescribeParametersInput{
+		ParameterFilters: []ssmtypes.ParameterStringFilter{
+			{Key: aws.String("Name"), Option: aws.String("Equals"), Values: []string{name}},
+			{Key: aws.String("Type"), Values: []string{string(ssmtypes.ParameterTypeSecureString)}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Parameters) == 0 {
+		return nil, nil
+	}
+
+	tags, err := client.ListTagsForResource(context.TODO(), &ssm.ListTagsForResourceInput{
+		ResourceType: ssmtypes.ResourceTypeForTaggingParameter,
+		ResourceId:   aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entry := secretEntry{Store: storeParameterStore, Name: name, Tags: map[string]string{}}
+	if out.Parameters[0].LastModifiedDate != nil {
+		entry.LastChanged = *out.Parameters[0].LastModifiedDate
+	}
+	for _, tag := range tags.TagList {
+		entry.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return &entry, nil
+}
+
+// secretValue fetches the decrypted value of the secret.
+func secretValue(cfg aws.Config, entry secretEntry) (string, error) {
+	if entry.Store == storeParameterStore {
+		out, err := ssm.NewFromConfig(cfg).GetParameter(context.TODO(), &ssm.GetParameterInput{
+			Name:           aws.String(entry.Name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(out.Parameter.Value), nil
+	}
+
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(context.TODO(), &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(entry.Name),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return string(out.SecretBinary), nil
+	}
+	return aws.ToString(out.SecretString), nil
+}
+
+// putSecretValue stores the value, creating the secret with the scope tag if it does not exist yet.
+func putSecretValue(cfg aws.Config, store string, name string, value string, exists bool, tagKey string, tagValue string) error {
+	if store == storeParameterStore {
+		in := &ssm.PutParameterInput{
+			Name:      aws.String(name),
+			Value:     aws.String(value),
+			Type:      ssmtypes.ParameterTypeSecureString,
+			Overwrite: aws.Bool(exists),
+		}
+		// tags can only be given when creating a parameter
+		if !exists && tagKey != "" {
+			in.Tags = []ssmtypes.Tag{{Key: aws.String(tagKey), Value: aws.String(tagValue)}}
+		}
+		_, err := ssm.NewFromConfig(cfg).PutParameter(context.TODO(), in)
+		return err
+	}
+
+	client := secretsmanager.NewFromConfig(cfg)
+	if exists {
+		_, err := client.PutSecretValue(context.TODO(), &secretsmanager.PutSecretValueInput{
+			SecretId:     aws.String(name),
+			SecretString: aws.String(value),
+		})
+		return err
+	}
+
+	in := &secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		SecretString: aws.String(value),
+	}
+	if tagKey != "" {
+		in.Tags = []smtypes.Tag{{Key: aws.String(tagKey), Value: aws.String(tagValue)}}
+	}
+	_, err := client.CreateSecret(context.TODO(), in)
+	return err
+}
+
+// deleteSecret deletes the secret. Secrets Manager secrets can be restored during the recovery window unless
+// force is set, parameters are deleted immediately.
+func deleteSecret(cfg aws.Config, entry secretEntry, force bool) error {
+	if entry.Store == storeParameterStore {
+		_, err := ssm.NewFromConfig(cfg).DeleteParameter(context.TODO(), &ssm.DeleteParameterInput{Name: aws.String(entry.Name)})
+		return err
+	}
+
+	_, err := secretsmanager.NewFromConfig(cfg).DeleteSecret(context.TODO(), &secretsmanager.DeleteSecretInput{
+		SecretId:                   aws.String(entry.Name),
+		ForceDeleteWithoutRecovery: aws.Bool(force),
+	})
+	return err
+}
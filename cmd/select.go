@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+)
+
+// requireTerminal returns an error if no terminal is attached. Interactive sessions put the terminal into raw mode,
+// which requires one.
+func requireTerminal() error {
+	if !isInteractive() {
+		return errors.New("stdin is not a terminal, an interactive session requires one")
+	}
+	return nil
+}
+
+// parseTag splits the tag given with the flag as key=value.
+func parseTag(flag string, tag string) (key string, value string, err error) {
+	key, value, ok := strings.Cut(tag, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid tag %q, please use %s key=value", tag, flag)
+	}
+	return key, value, nil
+}
+
+// selectOne lets the user select one of the items. A single item is selected right away; if there are several and
+// no prompt is possible, the error names the flag to choose with.
+func selectOne(label string, items []string, flag string) (int, error) {
+	switch {
+	case len(items) == 0:
+		return 0, errors.New("nothing found")
+	case len(items) == 1:
+		return 0, nil
+	case !canPrompt():
+		return 0, fmt.Errorf("%d choices found, please select one with %s", len(items), flag)
+	}
+
+	prompt := promptui.Select{
+		Label: label,
+		Items: items,
+		Size:  10,
+		// "/" starts a search
+		Searcher: func(input string, index int) bool {
+			return strings.Contains(strings.ToLower(items[index]), strings.ToLower(input))
+		},
+	}
+
+	idx, _, err := prompt.Run()
+	if err != nil {
+		return 0, fmt.Errorf("prompt failed: %v", err)
+	}
+	return idx, nil
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import "testing"
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		tag   string
+		key   string
+		value string
+		ok    bool
+	}{
+		{"environment=staging", "environment", "staging", true},
+		{"Name=", "Name", "", true},
+		{"expr=a=b", "expr", "a=b", true},
+		{"environment", "", "", false},
+		{"=staging", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		key, value, err := parseTag("--tag", tt.tag)
+		if (err == nil) != tt.ok || key != tt.key || value != tt.value {
+			t.Errorf("parseTag(%q) = %q, %q, %v", tt.tag, key, value, err)
+		}
+	}
+}
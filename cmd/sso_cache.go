@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+)
+
+// ssoCachedToken is an SSO access token in the format of the AWS CLI and SDKs, which read it from
+// ~/.aws/sso/cache/<sha1 of the key>.json. The key is the name of the sso-session, or the start URL for profiles
+// configured without one.
+type ssoCachedToken struct {
+	StartURL              string     `json:"startUrl"`
+	Region                string     `json:"region"`
+	AccessToken           string     `json:"accessToken"`
+	ExpiresAt             time.Time  `json:"expiresAt"`
+	RefreshToken          string     `json:"refreshToken,omitempty"`
+	ClientID              string     `json:"clientId,omitempty"`
+	ClientSecret          string     `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt *time.Time `json:"registrationExpiresAt,omitempty"`
+}
+
+// writeSSOTokenCache writes the token to the cache file of each key, readable by the user only.
+func writeSSOTokenCache(token ssoCachedToken, keys ...string) error {
+	// the SDKs expect RFC 3339 timestamps in UTC, the AWS CLI without fractional seconds
+	token.ExpiresAt = token.ExpiresAt.UTC().Truncate(time.Second)
+	if token.RegistrationExpiresAt != nil {
+		registrationExpiresAt := token.RegistrationExpiresAt.UTC().Truncate(time.Second)
+		token.RegistrationExpiresAt = &registrationExpiresAt
+	}
+
+	b, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		path, err := ssocreds.StandardCachedTokenFilepath(key)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			return fmt.Errorf("unable to write %s, %w", path, err)
+		}
+	}
+	return nil
+}
+
+// readSSOTokenCache reads the cached token for the key.
+func readSSOTokenCache(key string) (ssoCachedToken, string, error) {
+	var token ssoCachedToken
+	path, err := ssocreds.StandardCachedTokenFilepath(key)
+	if err != nil {
+		return token, "", err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return token, path, err
+	}
+	if err := json.Unmarshal(b, &token); err != nil {
+		return token, path, fmt.Errorf("unable to parse %s, %w", path, err)
+	}
+	return token, path, nil
+}
@@ -0,0 +1,394 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// tunnelCmd represents the tunnel command
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel",
+	Short: "Manage port-forwards running in the background.",
+	Long: `Manage port-forwards running in the background. Use one of the sub-commands.
+	* list: List all port-forwards started with "terra3 db port-forward --detach".
+	* stop: Stop a port-forward by its id, or all of them.
+	`,
+}
+
+var tunnelListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List port-forwards running in the background.",
+	Long: `List port-forwards running in the background. Entries of port-forwards whose process has died are
+	removed automatically, their log files after a day.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		tunnelList()
+	},
+}
+
+var tunnelStopCmd = &cobra.Command{
+	Use:   "stop <id|all>",
+	Short: "Stop a port-forward running in the background.",
+	Long:  `Stop a port-forward running in the background by its id as shown by "terra3 tunnel list", or all of them.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tunnelStop(args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tunnelCmd)
+	tunnelCmd.AddCommand(tunnelListCmd)
+	tunnelCmd.AddCommand(tunnelStopCmd)
+}
+
+const (
+	// tunnelStartupTimeout is the time to wait for the local port of a port-forward to accept connections.
+	tunnelStartupTimeout = 60 * time.Second
+	// tunnelLogRetention is the time the log file of a dead port-forward is kept for finding out why it died.
+	tunnelLogRetention = 24 * time.Hour
+)
+
+// tunnelState is the record of a port-forward running in the background. ProcessStart tells its process apart from
+// a later one that got the same pid, see processStartID.
+type tunnelState struct {
+	ID           string    `json:"id"`
+	PID          int       `json:"pid"`
+	Target       string    `json:"target"`
+	Database     string    `json:"database"`
+	Host         string    `json:"host"`
+	RemotePort   int32     `json:"remotePort"`
+	LocalPort    int       `json:"localPort"`
+	Profile      string    `json:"profile"`
+	Region       string    `json:"region"`
+	LogFile      string    `json:"logFile"`
+	StartedAt    time.Time `json:"startedAt"`
+	ProcessStart string    `json:"processStart,omitempty"`
+}
+
+// tunnelStateDir returns the directory holding the records of port-forwards running in the background.
+func tunnelStateDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "terra3", "tunnels")
+	return dir, os.MkdirAll(dir, 0o700)
+}
+
+func (s tunnelState) save() error {
+	dir, err := tunnelStateDir()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, s.ID+".json"), data, 0o600)
+}
+
+// remove deletes the record and the log file of a stopped port-forward.
+func (s tunnelState) remove() error {
+	dir, err := tunnelStateDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(dir, s.ID+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(s.LogFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// loadTunnelStates returns the records of all port-forwards whose process is still alive. Records of dead
+// processes are removed. Their log files usually tell why the port-forward died, so they are only removed once
+// they have not been written to for tunnelLogRetention.
+func loadTunnelStates() ([]tunnelState, error) {
+	dir, err := tunnelStateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var states []tunnelState
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var s tunnelState
+		if err := json.Unmarshal(data, &s); err != nil {
+			log.Printf("removing unreadable tunnel record %s, %v", file, err)
+			_ = os.Remove(file)
+			continue
+		}
+
+		if !s.alive() {
+			_ = os.Remove(file)
+			continue
+		}
+		states = append(states, s)
+	}
+
+	removeStaleTunnelLogs(dir, states)
+	return states, nil
+}
+
+// removeStaleTunnelLogs deletes the log files in dir which belong to none of the running port-forwards and have
+// not been written to for tunnelLogRetention.
+func removeStaleTunnelLogs(dir string, running []tunnelState) {
+	logs, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return
+	}
+
+	keep := map[string]bool{}
+	for _, s := range running {
+		keep[s.LogFile] = true
+	}
+	for _, file := range logs {
+		if keep[file] {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) > tunnelLogRetention {
+			_ = os.Remove(file)
+		}
+	}
+}
+
+// alive reports whether the process of the port-forward is still running. A process which got the pid of the
+// port-forward after it died is told apart by its start time, so it is neither listed nor stopped.
+func (s tunnelState) alive() bool {
+	if !processAlive(s.PID) {
+		return false
+	}
+	if s.ProcessStart == "" {
+		return true
+	}
+	start, err := processStartID(s.PID)
+	return err == nil && start == s.ProcessStart
+}
+
+func tunnelList() {
+	states, err := loadTunnelStates()
+	if err != nil {
+		log.Fatalf("unable to load tunnels, %v", err)
+	}
+
+	if len(states) == 0 {
+		fmt.Println("No port-forwards running in the background.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPID\tLOCAL PORT\tREMOTE\tTARGET\tPROFILE\tSTARTED")
+	for _, s := range states {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s:%d\t%s\t%s\t%s\n",
+			s.ID, s.PID, s.LocalPort, s.Host, s.RemotePort, s.Target, s.Profile, s.StartedAt.Local().Format(time.DateTime))
+	}
+	w.Flush()
+}
+
+func tunnelStop(id string) {
+	states, err := loadTunnelStates()
+	if err != nil {
+		log.Fatalf("unable to load tunnels, %v", err)
+	}
+
+	stopped := 0
+	for _, s := range states {
+		if id != "all" && s.ID != id {
+			continue
+		}
+
+		p, err := os.FindProcess(s.PID)
+		if err == nil {
+			err = stopProcess(p)
+		}
+		if err != nil {
+			log.Printf("unable to stop port-forward %s, %v", s.ID, err)
+			continue
+		}
+
+		_ = s.remove()
+		fmt.Printf("Stopped port-forward %s on local port %d.\n", s.ID, s.LocalPort)
+		stopped++
+	}
+
+	if stopped == 0 && id != "all" {
+		log.Fatalf("no port-forward with id %s found", id)
+	}
+}
+
+// detachTunnel starts the port-forward in a background process, waits until it accepts connections and records it
+// in the state directory.
+func detachTunnel(cfg aws.Config, bastionHostID string, db dbTarget, port int) {
+	id := uuid.NewString()[:8]
+
+	dir, err := tunnelStateDir()
+	if err != nil {
+		log.Fatalf("unable to create tunnel state directory, %v", err)
+	}
+
+	logFile := filepath.Join(dir, id+".log")
+	output, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		log.Fatalf("unable to create tunnel log file, %v", err)
+	}
+	defer output.Close()
+
+	// the detached port-forward follows --reconnect, as there is nobody left to restart it
+	tunnel, err := newTunnelProcess(cfg, bastionHostID, db.Address, db.Port, port, true)
+	if err != nil {
+		log.Fatalf("unable to start port-forward, %v", err)
+	}
+	tunnel.cmd.Stdout = output
+	tunnel.cmd.Stderr = output
+	detachProcess(tunnel.cmd)
+
+	if err := tunnel.start(); err != nil {
+		log.Fatalf("unable to start port-forward, %v", err)
+	}
+
+	if err := tunnel.waitForLocalPort(port, tunnelStartupTimeout); err != nil {
+		tunnel.stop()
+		log.Fatalf("port-forward did not come up, %v. See %s for details.", err, logFile)
+	}
+
+	processStart, err := processStartID(tunnel.cmd.Process.Pid)
+	if err != nil {
+		tunnel.stop()
+		log.Fatalf("unable to record port-forward, %v", err)
+	}
+
+	state := tunnelState{
+		ID:           id,
+		PID:          tunnel.cmd.Process.Pid,
+		Target:       bastionHostID,
+		Database:     db.Identifier,
+		Host:         db.Address,
+		RemotePort:   db.Port,
+		LocalPort:    port,
+		Profile:      os.Getenv("AWS_PROFILE"),
+		Region:       cfg.Region,
+		LogFile:      logFile,
+		StartedAt:    time.Now(),
+		ProcessStart: processStart,
+	}
+	if err := state.save(); err != nil {
+		tunnel.stop()
+		log.Fatalf("unable to record port-forward, %v", err)
+	}
+
+	fmt.Printf("Port-forward %s running in the background on local port %d (pid %d).\n", id, port, state.PID)
+	fmt.Printf("Use \"terra3 tunnel stop %s\" to stop it.\n", id)
+}
+
+// tunnelProcess is a port-forward running in a child process.
+type tunnelProcess struct {
+	cmd *exec.Cmd
+	// done is closed once the process has exited.
+	done chan struct{}
+}
+
+// newTunnelProcess prepares a child process running "terra3 tunnel run" for the remote host. If supervise is
+// true, the child reconnects as requested by --reconnect, otherwise it runs a single session. Keepalive frames are
+// sent as requested by --keepalive either way. Output and process attributes can be set on cmd before calling start.
+func newTunnelProcess(cfg aws.Config, target string, host string, remotePort int32, localPort int, supervise bool) (*tunnelProcess, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"tunnel", "run",
+		"--region", cfg.Region,
+		"--target", target,
+		"--host", host,
+		"--remote-port", strconv.Itoa(int(remotePort)),
+		"--local-port", strconv.Itoa(localPort),
+	}
+	if supervise {
+		args = append(args, "--reconnect="+strconv.FormatBool(reconnect))
+	} else {
+		args = append(args, "--reconnect=false")
+	}
+	args = append(args, "--keepalive="+keepalive.String())
+
+	t := &tunnelProcess{
+		cmd:  exec.Command(executable, args...),
+		done: make(chan struct{}),
+	}
+	// the selected profile is passed on through AWS_PROFILE
+	t.cmd.Env = os.Environ()
+	return t, nil
+}
+
+func (t *tunnelProcess) start() error {
+	if err := t.cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		_ = t.cmd.Wait()
+		close(t.done)
+	}()
+	return nil
+}
+
+// stop shuts the port-forward down and waits for the process to exit.
+func (t *tunnelProcess) stop() {
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+
+	_ = stopProcess(t.cmd.Process)
+	<-t.done
+}
+
+// waitForLocalPort waits until the port-forward accepts connections on port, or fails if the tunnel process
+// exits or timeout elapses.
+func (t *tunnelProcess) waitForLocalPort(port int, timeout time.Duration) error {
+	address := net.JoinHostPort("localhost", strconv.Itoa(port))
+	deadline := time.After(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+
+		select {
+		case <-t.done:
+			return errors.New("port-forward exited unexpectedly")
+		case <-deadline:
+			return fmt.Errorf("local port %d not ready after %s", port, timeout)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/it-objects/terra3-cli/ssmclient"
+	"github.com/spf13/cobra"
+)
+
+// tunnelRunCmd runs the port-forward of a background or supervised tunnel. It is not meant to be called directly.
+var tunnelRunCmd = &cobra.Command{
+	Use:    "run",
+	Short:  "Run a port-forward session to a remote host.",
+	Long:   `Run a port-forward session to a remote host. Used internally for background and supervised port-forwards.`,
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		tunnelRun()
+	},
+}
+
+var (
+	tunnelTarget     string
+	tunnelHost       string
+	tunnelRemotePort int
+	reconnect        bool
+	keepalive        time.Duration
+)
+
+const (
+	// reconnectMinBackoff is the delay before the first attempt to reconnect a closed session.
+	reconnectMinBackoff = time.Second
+	// reconnectMaxBackoff caps the exponentially growing delay between reconnect attempts.
+	reconnectMaxBackoff = time.Minute
+	// reconnectResetAfter is the time a session needs to have been running to reset the delay to its minimum.
+	reconnectResetAfter = time.Minute
+)
+
+func init() {
+	tunnelCmd.AddCommand(tunnelRunCmd)
+	tunnelRunCmd.Flags().StringVar(&tunnelTarget, "target", "", "EC2 instance ID of the bastion host.")
+	tunnelRunCmd.Flags().StringVar(&tunnelHost, "host", "", "Remote host to forward to.")
+	tunnelRunCmd.Flags().IntVar(&tunnelRemotePort, "remote-port", 0, "Remote port to forward to.")
+	tunnelRunCmd.Flags().IntVarP(&localPort, "local-port", "l", 0, "Local port to listen on.")
+	tunnelRunCmd.Flags().BoolVar(&reconnect, "reconnect", false, "Start a new session on the same local port if the session is closed.")
+	tunnelRunCmd.Flags().DurationVar(&keepalive, "keepalive", 0, "Optional interval in which keepalive frames are sent through the session to prevent the idle timeout.")
+	_ = tunnelRunCmd.MarkFlagRequired("target")
+	_ = tunnelRunCmd.MarkFlagRequired("host")
+	_ = tunnelRunCmd.MarkFlagRequired("remote-port")
+	_ = tunnelRunCmd.MarkFlagRequired("local-port")
+}
+
+func tunnelRun() {
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	if reconnect {
+		superviseTunnel(cfg, tunnelTarget, tunnelHost, int32(tunnelRemotePort), localPort)
+		return
+	}
+
+	ssm_tunnel(tunnelTarget, tunnelHost, int32(tunnelRemotePort), localPort)
+}
+
+// superviseTunnel runs the port-forward session in a child process, which keeps a session that failed in an
+// unexpected way from taking the supervisor down with it. A new session is started on the same local port after
+// the child exits, with an exponentially growing delay between attempts. Supervision ends on SIGINT or SIGTERM,
+// after the child terminated its session.
+func superviseTunnel(cfg aws.Config, target string, host string, remotePort int32, localPort int) {
+	ctx, stop := ssmclient.WithInterrupt(context.Background())
+	defer stop()
+
+	backoff := reconnectMinBackoff
+	for {
+		tunnel, err := newTunnelProcess(cfg, target, host, remotePort, localPort, false)
+		if err != nil {
+			log.Fatalf("unable to start port-forward, %v", err)
+		}
+		tunnel.cmd.Stdout = os.Stdout
+		tunnel.cmd.Stderr = os.Stderr
+		// signals are forwarded by stopping the child; getting a Ctrl-C from the terminal on top of that would
+		// kill it before it terminated its session
+		isolateProcess(tunnel.cmd)
+
+		started := time.Now()
+		if err := tunnel.start(); err != nil {
+			log.Fatalf("unable to start port-forward, %v", err)
+		}
+
+		select {
+		case <-tunnel.done:
+		case <-ctx.Done():
+			tunnel.stop()
+			exitOnInterrupt(context.Cause(ctx))
+			return
+		}
+
+		if time.Since(started) > reconnectResetAfter {
+			backoff = reconnectMinBackoff
+		}
+		log.Printf("port-forward session ended, reconnecting in %s...", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			exitOnInterrupt(context.Cause(ctx))
+			return
+		}
+
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// exitOnInterrupt exits with the conventional exit code if err reports that a signal ended the session.
+func exitOnInterrupt(err error) {
+	var interrupted *ssmclient.InterruptedError
+	if errors.As(err, &interrupted) {
+		os.Exit(interrupted.ExitCode())
+	}
+}
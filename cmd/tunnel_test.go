@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestTunnelStateAlive(t *testing.T) {
+	start, err := processStartID(os.Getpid())
+	if err != nil {
+		t.Fatalf("processStartID: %v", err)
+	}
+	if again, _ := processStartID(os.Getpid()); again != start {
+		t.Fatalf("start of the same process changed from %q to %q", start, again)
+	}
+
+	tests := []struct {
+		name  string
+		state tunnelState
+		want  bool
+	}{
+		{"same process", tunnelState{PID: os.Getpid(), ProcessStart: start}, true},
+		{"record without start", tunnelState{PID: os.Getpid()}, true},
+		{"reused pid", tunnelState{PID: os.Getpid(), ProcessStart: start + "0"}, start == ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.alive(); got != tt.want {
+				t.Errorf("alive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
+	"github.com/aws/smithy-go"
+	"github.com/spf13/cobra"
+)
+
+// whoamiCmd represents the whoami command
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the AWS identity of the current credentials.",
+	Long: `Show the AWS account, its aliases, the region and the IAM identity of the current credentials. Without
+	--profile, the default credentials are used, e.g. from AWS_PROFILE. Exits with a non-zero code if no valid
+	credentials are found.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		whoami()
+	},
+}
+
+var whoamiOutput string
+
+func init() {
+	rootCmd.AddCommand(whoamiCmd)
+	whoamiCmd.Flags().StringVarP(&whoamiOutput, "output", "o", "text", "Output format: text or json.")
+}
+
+func whoami() {
+	if whoamiOutput != "text" && whoamiOutput != "json" {
+		log.Fatalf("invalid output format %q, please use text or json", whoamiOutput)
+	}
+
+	// without --profile or --profile-filter, the current credentials are shown instead of opening a menu
+	if profile != "" || profileFilter != "" {
+		selectProfile()
+	}
+
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	identity, err := NewWhoami(cfg, NewWhoamiParams())
+	if err != nil {
+		log.Fatal(credentialsError(err))
+	}
+
+	if whoamiOutput == "json" {
+		out, err := json.MarshalIndent(identity, "", "  ")
+		if err != nil {
+			log.Fatalf("unable to format identity, %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Println(identity.Format())
+}
+
+// credentialsError adds a hint to log in again if the credentials have expired.
+func credentialsError(err error) error {
+	if isExpiredCredentials(err) {
+		return fmt.Errorf("the AWS credentials have expired, please run \"terra3 login\" and try again: %w", err)
+	}
+	return fmt.Errorf("unable to get caller identity, %w", err)
+}
+
+// isExpiredCredentials reports whether the error is caused by an expired SSO session or expired temporary
+// credentials.
+func isExpiredCredentials(err error) bool {
+	var invalidToken *ssocreds.InvalidTokenError
+	if errors.As(err, &invalidToken) {
+		return true
+	}
+
+	// GetRoleCredentials rejects an SSO access token that has expired
+	var unauthorized *ssotypes.UnauthorizedException
+	if errors.As(err, &unauthorized) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ExpiredToken", "ExpiredTokenException", "RequestExpired":
+			return true
+		}
+	}
+
+	// the SSO token provider used with sso-session configurations returns plain errors
+	return strings.Contains(err.Error(), "cached SSO token is expired") ||
+		strings.Contains(err.Error(), "refresh cached SSO token failed")
+}
@@ -0,0 +1,561 @@
+package ssmclient
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// clientVersion is reported to the agent during the handshake. The agent enables features based on it, so it
+	// follows the version of the session-manager-plugin whose protocol is implemented here.
+	clientVersion = "1.2.0.0"
+
+	// streamDataPayloadSize is the maximum size of a single stream data payload.
+	streamDataPayloadSize = 1024
+
+	// outgoingMessageWindow is the number of unacknowledged messages after which sending blocks.
+	outgoingMessageWindow = 10000
+	// incomingMessageBuffer is the number of out-of-order messages kept until the missing ones arrive.
+	incomingMessageBuffer = 10000
+
+	resendInterval       = 100 * time.Millisecond
+	resendMaxAttempts    = 3000
+	initialRoundTripTime = 100 * time.Millisecond
+	minResendTimeout     = 200 * time.Millisecond
+	maxResendTimeout     = time.Second
+	pingInterval         = 5 * time.Minute
+	handshakeTimeout     = 30 * time.Second
+)
+
+// Versions of the SSM agent after which protocol features are available.
+const (
+	terminateSessionFlagAgentVersion = "2.3.722.0"
+	multiplexingAgentVersion         = "3.0.196.0"
+	smuxKeepAliveDisabledVersion     = "3.1.1511.0"
+)
+
+var (
+	// ErrSessionClosed is the error returned if the session was closed on the server side, e.g. after the idle timeout.
+	ErrSessionClosed = errors.New("session closed")
+	// ErrHandshakeTimeout is the error returned if the agent did not complete the handshake in time.
+	ErrHandshakeTimeout = errors.New("session handshake timed out")
+	// ErrResendTimeout is the error returned if a message was not acknowledged after all resend attempts.
+	ErrResendTimeout = errors.New("message not acknowledged by the agent")
+	// ErrKMSEncryptionUnsupported is the error returned if the session requires KMS encryption, which only the
+	// session-manager-plugin implements.
+	ErrKMSEncryptionUnsupported = errors.New("session requires KMS encryption, which is not supported")
+)
+
+// outgoingMessage is a sent message waiting for its acknowledgement.
+type outgoingMessage struct {
+	sequenceNumber int64
+	content        []byte
+	lastSent       time.Time
+	attempts       int
+}
+
+// dataChannel speaks the SSM data channel protocol over the websocket returned by ssm:StartSession. It takes care of
+// the handshake, acknowledgements, ordering of incoming messages by sequence number and resending of outgoing ones.
+type dataChannel struct {
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+
+	// sessionType is the session type the client accepts in the handshake, e.g. "Port"
+	sessionType string
+	// output receives the payloads of stream data messages in order
+	output func(payloadType uint32, payload []byte)
+
+	lock           sync.Mutex
+	sequenceNumber int64
+	outgoing       *list.List
+	window         chan struct{}
+	expected       int64
+	incoming       map[int64]*dataChannelMessage
+	roundTripTime  time.Duration
+	roundTripVar   time.Duration
+	resendTimeout  time.Duration
+	agentVersion   string
+	handshakeDone  chan struct{}
+	done           chan struct{}
+	err            error
+}
+
+// openDataChannel connects to the stream URL of a session and authenticates with the session token. The returned data
+// channel is ready to send once waitForHandshake returns.
+func openDataChannel(ctx context.Context, streamURL string, tokenValue string, sessionType string, output func(uint32, []byte)) (*dataChannel, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, streamURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &dataChannel{
+		conn:          conn,
+		sessionType:   sessionType,
+		output:        output,
+		outgoing:      list.New(),
+		window:        make(chan struct{}, outgoingMessageWindow),
+		incoming:      map[int64]*dataChannelMessage{},
+		roundTripTime: initialRoundTripTime,
+		resendTimeout: minResendTimeout,
+		handshakeDone: make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	open, err := json.Marshal(map[string]string{
+		"MessageSchemaVersion": "1.0",
+		"RequestId":            uuid.NewString(),
+		"TokenValue":           tokenValue,
+		"ClientId":             uuid.NewString(),
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.write(websocket.TextMessage, open); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.readLoop()
+	go c.resendLoop()
+	go c.pingLoop()
+	return c, nil
+}
+
+// waitForHandshake blocks until the handshake requested by the agent was answered, the channel closed or the context is done.
+func (c *dataChannel) waitForHandshake(ctx context.Context) error {
+	timer := time.NewTimer(handshakeTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.handshakeDone:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-timer.C:
+		return ErrHandshakeTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AgentVersion returns the version of the SSM agent reported during the handshake.
+func (c *dataChannel) AgentVersion() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.agentVersion
+}
+
+// Done is closed when the data channel is closed.
+func (c *dataChannel) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the data channel was closed, or nil if it was closed by Close.
+func (c *dataChannel) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// Close closes the websocket. Sessions are not terminated, use sendFlag with flagTerminateSession for that.
+func (c *dataChannel) Close() error {
+	return c.closeWithError(nil)
+}
+
+func (c *dataChannel) closeWithError(err error) error {
+	c.lock.Lock()
+	select {
+	case <-c.done:
+		c.lock.Unlock()
+		return nil
+	default:
+	}
+	c.err = err
+	close(c.done)
+	c.lock.Unlock()
+
+	c.writeLock.Lock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeLock.Unlock()
+	return c.conn.Close()
+}
+
+// sendFlag sends a control flag to the agent.
+func (c *dataChannel) sendFlag(flag uint32) error {
+	return c.sendInput(payloadFlag, flagPayload(flag))
+}
+
+// Write sends data to the remote end of the session, split into stream data messages of the maximum payload size.
+func (c *dataChannel) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > streamDataPayloadSize {
+			n = streamDataPayloadSize
+		}
+		// the payload is kept for resending, so it must not share memory with the caller's buffer
+		if err := c.sendInput(payloadOutput, append([]byte(nil), p[:n]...)); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// sendInput sends an input stream data message and keeps it for resending until it is acknowledged. It blocks while
+// the window of unacknowledged messages is full.
+func (c *dataChannel) sendInput(payloadType uint32, payload []byte) error {
+	// a free window must not hide that the channel is closed
+	select {
+	case <-c.done:
+		return c.closedErr()
+	default:
+	}
+
+	select {
+	case c.window <- struct{}{}:
+	case <-c.done:
+		return c.closedErr()
+	}
+
+	c.lock.Lock()
+	msg := newDataChannelMessage(inputStreamMessage, payloadType, payload)
+	msg.SequenceNumber = c.sequenceNumber
+	content, err := msg.MarshalBinary()
+	if err != nil {
+		c.lock.Unlock()
+		<-c.window
+		return err
+	}
+	c.sequenceNumber++
+	c.outgoing.PushBack(&outgoingMessage{sequenceNumber: msg.SequenceNumber, content: content, lastSent: time.Now()})
+	c.lock.Unlock()
+
+	return c.write(websocket.BinaryMessage, content)
+}
+
+// closedErr returns the error to report for operations on a closed data channel.
+func (c *dataChannel) closedErr() error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return net.ErrClosed
+}
+
+func (c *dataChannel) write(messageType int, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		// report why the channel was closed rather than the websocket error following from it
+		select {
+		case <-c.done:
+			return c.closedErr()
+		default:
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *dataChannel) readLoop() {
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.closeWithError(err)
+			return
+		}
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		msg := new(dataChannelMessage)
+		if err := msg.UnmarshalBinary(data); err != nil {
+			c.closeWithError(err)
+			return
+		}
+
+		if err := c.handleMessage(msg); err != nil {
+			c.closeWithError(err)
+			return
+		}
+	}
+}
+
+func (c *dataChannel) handleMessage(msg *dataChannelMessage) error {
+	switch msg.MessageType {
+	case outputStreamMessage:
+		return c.handleOutput(msg)
+	case acknowledgeMessage:
+		var ack acknowledgeContent
+		if err := json.Unmarshal(msg.Payload, &ack); err != nil {
+			return err
+		}
+		c.handleAcknowledge(ack.SequenceNumber)
+	case channelClosedMessage:
+		var closed channelClosed
+		_ = json.Unmarshal(msg.Payload, &closed)
+		if closed.Output != "" {
+			return fmt.Errorf("%w: %s", ErrSessionClosed, closed.Output)
+		}
+		return ErrSessionClosed
+	case startPublicationMessage, pausePublicationMessage:
+		// the agent is flow-controlled by the acknowledgements already
+	}
+	return nil
+}
+
+// handleOutput acknowledges an output stream message and processes it together with all buffered messages following it
+// in sequence. Messages ahead of the expected sequence number are buffered, messages already processed are
+// acknowledged again since the agent resends them when an acknowledgement got lost.
+func (c *dataChannel) handleOutput(msg *dataChannelMessage) error {
+	c.lock.Lock()
+	expected := c.expected
+	if msg.SequenceNumber > expected {
+		if len(c.incoming) >= incomingMessageBuffer {
+			c.lock.Unlock()
+			return nil
+		}
+		c.incoming[msg.SequenceNumber] = msg
+	}
+	c.lock.Unlock()
+
+	if err := c.acknowledge(msg); err != nil {
+		return err
+	}
+	if msg.SequenceNumber != expected {
+		return nil
+	}
+
+	for msg != nil {
+		if err := c.process(msg); err != nil {
+			return err
+		}
+
+		c.lock.Lock()
+		c.expected++
+		msg = c.incoming[c.expected]
+		delete(c.incoming, c.expected)
+		c.lock.Unlock()
+	}
+	return nil
+}
+
+func (c *dataChannel) process(msg *dataChannelMessage) error {
+	switch msg.PayloadType {
+	case payloadHandshakeRequest:
+		return c.handleHandshakeRequest(msg.Payload)
+	case payloadHandshakeComplete:
+		// the session is usable as soon as the handshake response was sent
+	case payloadEncChallengeRequest:
+		return ErrKMSEncryptionUnsupported
+	default:
+		c.output(msg.PayloadType, msg.Payload)
+	}
+	return nil
+}
+
+func (c *dataChannel) handleHandshakeRequest(payload []byte) error {
+	var req handshakeRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.agentVersion = req.AgentVersion
+	c.lock.Unlock()
+
+	resp := handshakeResponse{ClientVersion: clientVersion, Errors: []string{}}
+	kmsRequested := false
+	for _, action := range req.RequestedClientActions {
+		processed := processedClientAction{ActionType: action.ActionType, ActionStatus: actionSuccess}
+		switch action.ActionType {
+		case actionSessionType:
+			var sessionType sessionTypeRequest
+			if err := json.Unmarshal(action.ActionParameters, &sessionType); err != nil || sessionType.SessionType != c.sessionType {
+				processed.ActionStatus = actionFailed
+				processed.Error = fmt.Sprintf("unsupported session type %q", sessionType.SessionType)
+			}
+		case actionKMSEncryption:
+			kmsRequested = true
+			processed.ActionStatus = actionFailed
+			processed.Error = "KMS encryption is not supported"
+		default:
+			processed.ActionStatus = actionUnsupported
+			processed.Error = fmt.Sprintf("unsupported action %s", action.ActionType)
+		}
+		if processed.Error != "" {
+			resp.Errors = append(resp.Errors, processed.Error)
+		}
+		resp.ProcessedClientActions = append(resp.ProcessedClientActions, processed)
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	if err := c.sendInput(payloadHandshakeResponse, b); err != nil {
+		return err
+	}
+	if kmsRequested {
+		return ErrKMSEncryptionUnsupported
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("session handshake failed: %s", strings.Join(resp.Errors, ", "))
+	}
+
+	select {
+	case <-c.handshakeDone:
+	default:
+		close(c.handshakeDone)
+	}
+	return nil
+}
+
+func (c *dataChannel) acknowledge(msg *dataChannelMessage) error {
+	payload, err := json.Marshal(acknowledgeContent{
+		MessageType:         msg.MessageType,
+		MessageID:           msg.MessageID.String(),
+		SequenceNumber:      msg.SequenceNumber,
+		IsSequentialMessage: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	ack := newDataChannelMessage(acknowledgeMessage, 0, payload)
+	ack.Flags = acknowledgeFlags
+	b, err := ack.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return c.write(websocket.BinaryMessage, b)
+}
+
+// handleAcknowledge removes the acknowledged message from the resend buffer and adapts the resend timeout to the
+// measured round trip time.
+func (c *dataChannel) handleAcknowledge(sequenceNumber int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for e := c.outgoing.Front(); e != nil; e = e.Next() {
+		msg := e.Value.(*outgoingMessage)
+		if msg.sequenceNumber != sequenceNumber {
+			continue
+		}
+
+		rtt := time.Since(msg.lastSent)
+		delta := c.roundTripTime - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		c.roundTripVar = (3*c.roundTripVar + delta) / 4
+		c.roundTripTime = (7*c.roundTripTime + rtt) / 8
+		c.resendTimeout = c.roundTripTime + 4*c.roundTripVar
+		if c.resendTimeout < minResendTimeout {
+			c.resendTimeout = minResendTimeout
+		}
+		if c.resendTimeout > maxResendTimeout {
+			c.resendTimeout = maxResendTimeout
+		}
+
+		c.outgoing.Remove(e)
+		<-c.window
+		return
+	}
+}
+
+// resendLoop resends the oldest unacknowledged message once its resend timeout expired.
+func (c *dataChannel) resendLoop() {
+	ticker := time.NewTicker(resendInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.lock.Lock()
+		var content []byte
+		if e := c.outgoing.Front(); e != nil {
+			msg := e.Value.(*outgoingMessage)
+			if time.Since(msg.lastSent) > c.resendTimeout {
+				msg.attempts++
+				msg.lastSent = time.Now()
+				content = msg.content
+				if msg.attempts > resendMaxAttempts {
+					c.lock.Unlock()
+					c.closeWithError(ErrResendTimeout)
+					return
+				}
+			}
+		}
+		c.lock.Unlock()
+
+		if content != nil {
+			if err := c.write(websocket.BinaryMessage, content); err != nil {
+				c.closeWithError(err)
+				return
+			}
+		}
+	}
+}
+
+// pingLoop keeps the websocket alive while no data is exchanged.
+func (c *dataChannel) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.writeLock.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, []byte("keepalive"), time.Now().Add(10*time.Second))
+			c.writeLock.Unlock()
+			if err != nil {
+				c.closeWithError(err)
+				return
+			}
+		}
+	}
+}
+
+// agentVersionAfter reports whether the agent version is greater than the given version. Unparsable versions are
+// considered older.
+func agentVersionAfter(agentVersion string, version string) bool {
+	a := strings.Split(agentVersion, ".")
+	b := strings.Split(version, ".")
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, err := strconv.Atoi(a[i])
+		if err != nil {
+			return false
+		}
+		y, err := strconv.Atoi(b[i])
+		if err != nil {
+			return false
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
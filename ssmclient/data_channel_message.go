@@ -0,0 +1,234 @@
+package ssmclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message types of the SSM data channel protocol.
+const (
+	inputStreamMessage      = "input_stream_data"
+	outputStreamMessage     = "output_stream_data"
+	acknowledgeMessage      = "acknowledge"
+	channelClosedMessage    = "channel_closed"
+	startPublicationMessage = "start_publication"
+	pausePublicationMessage = "pause_publication"
+)
+
+// Payload types of stream data messages.
+const (
+	payloadOutput               uint32 = 1
+	payloadError                uint32 = 2
+	payloadSize                 uint32 = 3
+	payloadParameter            uint32 = 4
+	payloadHandshakeRequest     uint32 = 5
+	payloadHandshakeResponse    uint32 = 6
+	payloadHandshakeComplete    uint32 = 7
+	payloadEncChallengeRequest  uint32 = 8
+	payloadEncChallengeResponse uint32 = 9
+	payloadFlag                 uint32 = 10
+	payloadStdErr               uint32 = 11
+	payloadExitCode             uint32 = 12
+)
+
+// Flags of the message header. Bit 0 (SYN) marks the first message of a sequence, bit 1 (FIN) the last one.
+const (
+	messageFlagSYN uint64 = 1 << 0
+	messageFlagFIN uint64 = 1 << 1
+
+	// acknowledgeFlags are the header flags of an acknowledge message, which is a sequence of its own.
+	acknowledgeFlags = messageFlagSYN | messageFlagFIN
+)
+
+// Flags sent as payload of type payloadFlag.
+const (
+	flagDisconnectToPort   uint32 = 1
+	flagTerminateSession   uint32 = 2
+	flagConnectToPortError uint32 = 3
+)
+
+/*
+ * Layout of a binary data channel message. All integers are big-endian.
+ *
+ * HL  - header length (4 bytes), the offset of the payload length field
+ * MT  - message type (32 bytes), padded with spaces
+ * SV  - schema version (4 bytes)
+ * CD  - created date in epoch millis (8 bytes)
+ * SN  - sequence number (8 bytes)
+ * F   - flags (8 bytes)
+ * MI  - message ID (16 bytes), least significant half first
+ * PD  - SHA-256 digest of the payload (32 bytes)
+ * PT  - payload type (4 bytes)
+ * PL  - payload length (4 bytes)
+ * P   - payload
+ */
+const (
+	messageTypeOffset    = 4
+	messageTypeLength    = 32
+	schemaVersionOffset  = messageTypeOffset + messageTypeLength
+	createdDateOffset    = schemaVersionOffset + 4
+	sequenceNumberOffset = createdDateOffset + 8
+	flagsOffset          = sequenceNumberOffset + 8
+	messageIDOffset      = flagsOffset + 8
+	payloadDigestOffset  = messageIDOffset + 16
+	payloadTypeOffset    = payloadDigestOffset + 32
+	payloadLengthOffset  = payloadTypeOffset + 4
+	payloadOffset        = payloadLengthOffset + 4
+)
+
+// ErrInvalidMessage is the error returned if a data channel message cannot be decoded.
+var ErrInvalidMessage = errors.New("invalid data channel message")
+
+// dataChannelMessage is a single message exchanged over the data channel websocket.
+type dataChannelMessage struct {
+	MessageType    string
+	SchemaVersion  uint32
+	CreatedDate    time.Time
+	SequenceNumber int64
+	Flags          uint64
+	MessageID      uuid.UUID
+	PayloadType    uint32
+	Payload        []byte
+}
+
+// newDataChannelMessage returns a message of the given type with a fresh message ID.
+func newDataChannelMessage(messageType string, payloadType uint32, payload []byte) *dataChannelMessage {
+	return &dataChannelMessage{
+		MessageType:   messageType,
+		SchemaVersion: 1,
+		CreatedDate:   time.Now(),
+		MessageID:     uuid.New(),
+		PayloadType:   payloadType,
+		Payload:       payload,
+	}
+}
+
+// MarshalBinary encodes the message in the wire format of the data channel.
+func (m *dataChannelMessage) MarshalBinary() ([]byte, error) {
+	if len(m.MessageType) > messageTypeLength {
+		return nil, ErrInvalidMessage
+	}
+
+	b := make([]byte, payloadOffset+len(m.Payload))
+	binary.BigEndian.PutUint32(b, payloadLengthOffset)
+	copy(b[messageTypeOffset:schemaVersionOffset], m.MessageType+strings.Repeat(" ", messageTypeLength-len(m.MessageType)))
+	binary.BigEndian.PutUint32(b[schemaVersionOffset:], m.SchemaVersion)
+	binary.BigEndian.PutUint64(b[createdDateOffset:], uint64(m.CreatedDate.UnixMilli()))
+	binary.BigEndian.PutUint64(b[sequenceNumberOffset:], uint64(m.SequenceNumber))
+	binary.BigEndian.PutUint64(b[flagsOffset:], m.Flags)
+	copy(b[messageIDOffset:], m.MessageID[8:])
+	copy(b[messageIDOffset+8:], m.MessageID[:8])
+	digest := sha256.Sum256(m.Payload)
+	copy(b[payloadDigestOffset:], digest[:])
+	binary.BigEndian.PutUint32(b[payloadTypeOffset:], m.PayloadType)
+	binary.BigEndian.PutUint32(b[payloadLengthOffset:], uint32(len(m.Payload)))
+	copy(b[payloadOffset:], m.Payload)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a message in the wire format of the data channel and verifies the payload digest.
+func (m *dataChannelMessage) UnmarshalBinary(b []byte) error {
+	if len(b) < payloadOffset {
+		return ErrInvalidMessage
+	}
+
+	headerLength := int(binary.BigEndian.Uint32(b))
+	if headerLength < payloadLengthOffset || len(b) < headerLength+4 {
+		return ErrInvalidMessage
+	}
+
+	m.MessageType = strings.TrimRight(string(b[messageTypeOffset:schemaVersionOffset]), " \x00")
+	m.SchemaVersion = binary.BigEndian.Uint32(b[schemaVersionOffset:])
+	m.CreatedDate = time.UnixMilli(int64(binary.BigEndian.Uint64(b[createdDateOffset:])))
+	m.SequenceNumber = int64(binary.BigEndian.Uint64(b[sequenceNumberOffset:]))
+	m.Flags = binary.BigEndian.Uint64(b[flagsOffset:])
+	copy(m.MessageID[8:], b[messageIDOffset:messageIDOffset+8])
+	copy(m.MessageID[:8], b[messageIDOffset+8:payloadDigestOffset])
+	m.PayloadType = binary.BigEndian.Uint32(b[payloadTypeOffset:])
+
+	payloadLength := int(binary.BigEndian.Uint32(b[headerLength:]))
+	start := headerLength + 4
+	if len(b) < start+payloadLength {
+		return ErrInvalidMessage
+	}
+	m.Payload = b[start : start+payloadLength]
+
+	if payloadLength > 0 {
+		digest := sha256.Sum256(m.Payload)
+		if !bytes.Equal(digest[:], b[payloadDigestOffset:payloadTypeOffset]) {
+			return ErrInvalidMessage
+		}
+	}
+	return nil
+}
+
+// flagPayload encodes a flag as payload of a payloadFlag message.
+func flagPayload(flag uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, flag)
+}
+
+// acknowledgeContent is the payload of an acknowledge message.
+type acknowledgeContent struct {
+	MessageType         string `json:"AcknowledgedMessageType"`
+	MessageID           string `json:"AcknowledgedMessageId"`
+	SequenceNumber      int64  `json:"AcknowledgedMessageSequenceNumber"`
+	IsSequentialMessage bool   `json:"IsSequentialMessage"`
+}
+
+// channelClosed is the payload of a channel_closed message.
+type channelClosed struct {
+	MessageID     string `json:"MessageId"`
+	CreatedDate   string `json:"CreatedDate"`
+	DestinationID string `json:"DestinationId"`
+	SessionID     string `json:"SessionId"`
+	MessageType   string `json:"MessageType"`
+	SchemaVersion int    `json:"SchemaVersion"`
+	Output        string `json:"Output"`
+}
+
+// Handshake action types and statuses.
+const (
+	actionKMSEncryption = "KMSEncryption"
+	actionSessionType   = "SessionType"
+
+	actionSuccess     = 1
+	actionFailed      = 2
+	actionUnsupported = 3
+)
+
+// handshakeRequest is the payload the agent starts a session with.
+type handshakeRequest struct {
+	AgentVersion           string `json:"AgentVersion"`
+	RequestedClientActions []struct {
+		ActionType       string          `json:"ActionType"`
+		ActionParameters json.RawMessage `json:"ActionParameters"`
+	} `json:"RequestedClientActions"`
+}
+
+// sessionTypeRequest are the parameters of the SessionType handshake action.
+type sessionTypeRequest struct {
+	SessionType string      `json:"SessionType"`
+	Properties  interface{} `json:"Properties"`
+}
+
+// processedClientAction reports the outcome of a requested handshake action.
+type processedClientAction struct {
+	ActionType   string      `json:"ActionType"`
+	ActionStatus int         `json:"ActionStatus"`
+	ActionResult interface{} `json:"ActionResult"`
+	Error        string      `json:"Error"`
+}
+
+// handshakeResponse is the payload answering a handshakeRequest.
+type handshakeResponse struct {
+	ClientVersion          string                  `json:"ClientVersion"`
+	ProcessedClientActions []processedClientAction `json:"ProcessedClientActions"`
+	Errors                 []string                `json:"Errors"`
+}
@@ -0,0 +1,419 @@
+package ssmclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testAgentVersion = "3.1.1732.0"
+
+// fakeAgent is the remote end of a data channel, standing in for the SSM service and agent.
+type fakeAgent struct {
+	t        *testing.T
+	server   *httptest.Server
+	conn     chan *websocket.Conn
+	open     chan map[string]string
+	messages chan *dataChannelMessage
+	ws       *websocket.Conn
+
+	// agentVersion is reported in the handshake, testAgentVersion if empty
+	agentVersion string
+	writeLock    sync.Mutex
+	// nextSequence is the sequence number of the next output message sent by serve
+	nextSequence int64
+}
+
+func newFakeAgent(t *testing.T) *fakeAgent {
+	a := &fakeAgent{
+		t:        t,
+		conn:     make(chan *websocket.Conn, 1),
+		open:     make(chan map[string]string, 1),
+		messages: make(chan *dataChannelMessage, 100),
+	}
+
+	upgrader := websocket.Upgrader{}
+	a.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer ws.Close()
+		a.conn <- ws
+
+		// the first message opens the data channel with the session token
+		messageType, data, err := ws.ReadMessage()
+		if err != nil || messageType != websocket.TextMessage {
+			t.Errorf("expected open data channel message, got type %d, %v", messageType, err)
+			return
+		}
+		var open map[string]string
+		if err := json.Unmarshal(data, &open); err != nil {
+			t.Errorf("invalid open data channel message: %v", err)
+			return
+		}
+		a.open <- open
+
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				close(a.messages)
+				return
+			}
+			msg := new(dataChannelMessage)
+			if err := msg.UnmarshalBinary(data); err != nil {
+				t.Errorf("invalid message from client: %v", err)
+				return
+			}
+			a.messages <- msg
+		}
+	}))
+	t.Cleanup(a.server.Close)
+	return a
+}
+
+func (a *fakeAgent) url() string {
+	return "ws" + strings.TrimPrefix(a.server.URL, "http")
+}
+
+// accept waits for the client to connect and checks the open data channel message.
+func (a *fakeAgent) accept() {
+	a.t.Helper()
+	select {
+	case a.ws = <-a.conn:
+	case <-time.After(5 * time.Second):
+		a.t.Fatal("client did not connect")
+	}
+	select {
+	case open := <-a.open:
+		if open["TokenValue"] != "token" {
+			a.t.Errorf("token = %q, want token", open["TokenValue"])
+		}
+		if open["MessageSchemaVersion"] != "1.0" {
+			a.t.Errorf("schema version = %q, want 1.0", open["MessageSchemaVersion"])
+		}
+	case <-time.After(5 * time.Second):
+		a.t.Fatal("client did not open the data channel")
+	}
+}
+
+func (a *fakeAgent) send(msg *dataChannelMessage) {
+	a.t.Helper()
+	if err := a.write(msg); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+func (a *fakeAgent) write(msg *dataChannelMessage) error {
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	return a.ws.WriteMessage(websocket.BinaryMessage, b)
+}
+
+func (a *fakeAgent) sendOutput(sequenceNumber int64, payloadType uint32, payload []byte) *dataChannelMessage {
+	a.t.Helper()
+	msg := newDataChannelMessage(outputStreamMessage, payloadType, payload)
+	msg.SequenceNumber = sequenceNumber
+	a.send(msg)
+	return msg
+}
+
+func (a *fakeAgent) sendAcknowledge(msg *dataChannelMessage) {
+	a.t.Helper()
+	a.send(acknowledgement(msg))
+}
+
+func acknowledgement(msg *dataChannelMessage) *dataChannelMessage {
+	payload, _ := json.Marshal(acknowledgeContent{
+		MessageType:         msg.MessageType,
+		MessageID:           msg.MessageID.String(),
+		SequenceNumber:      msg.SequenceNumber,
+		IsSequentialMessage: true,
+	})
+	return newDataChannelMessage(acknowledgeMessage, 0, payload)
+}
+
+// receive returns the next message of the client, which must be of the given type.
+func (a *fakeAgent) receive(messageType string) *dataChannelMessage {
+	a.t.Helper()
+	select {
+	case msg, ok := <-a.messages:
+		if !ok {
+			a.t.Fatalf("connection closed, expected %s", messageType)
+		}
+		if msg.MessageType != messageType {
+			a.t.Fatalf("message type = %s, want %s", msg.MessageType, messageType)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		a.t.Fatalf("no %s message received", messageType)
+	}
+	return nil
+}
+
+// receiveAcknowledge checks that the next message of the client acknowledges the given sequence number.
+func (a *fakeAgent) receiveAcknowledge(sequenceNumber int64) {
+	a.t.Helper()
+	msg := a.receive(acknowledgeMessage)
+	if msg.Flags != acknowledgeFlags {
+		a.t.Errorf("acknowledge flags = %d, want %d", msg.Flags, acknowledgeFlags)
+	}
+	var ack acknowledgeContent
+	if err := json.Unmarshal(msg.Payload, &ack); err != nil {
+		a.t.Fatal(err)
+	}
+	if ack.SequenceNumber != sequenceNumber || ack.MessageType != outputStreamMessage {
+		a.t.Fatalf("acknowledged %s %d, want %s %d", ack.MessageType, ack.SequenceNumber, outputStreamMessage, sequenceNumber)
+	}
+}
+
+// handshake requests the session type and returns the response of the client, which is acknowledged.
+func (a *fakeAgent) handshake(sessionType string, actions ...string) handshakeResponse {
+	a.t.Helper()
+	requested := []map[string]interface{}{{
+		"ActionType":       actionSessionType,
+		"ActionParameters": map[string]interface{}{"SessionType": sessionType},
+	}}
+	for _, action := range actions {
+		requested = append(requested, map[string]interface{}{"ActionType": action, "ActionParameters": map[string]string{}})
+	}
+	agentVersion := a.agentVersion
+	if agentVersion == "" {
+		agentVersion = testAgentVersion
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"AgentVersion":           agentVersion,
+		"RequestedClientActions": requested,
+	})
+	a.sendOutput(0, payloadHandshakeRequest, payload)
+	a.receiveAcknowledge(0)
+
+	msg := a.receive(inputStreamMessage)
+	if msg.PayloadType != payloadHandshakeResponse || msg.SequenceNumber != 0 {
+		a.t.Fatalf("got payload type %d with sequence number %d, want handshake response with 0", msg.PayloadType, msg.SequenceNumber)
+	}
+	// the client closes the channel right away if the handshake failed
+	_ = a.write(acknowledgement(msg))
+
+	var resp handshakeResponse
+	if err := json.Unmarshal(msg.Payload, &resp); err != nil {
+		a.t.Fatal(err)
+	}
+	return resp
+}
+
+// openTestDataChannel connects a data channel for port sessions to the agent, passing stream data to the returned
+// channel.
+func openTestDataChannel(t *testing.T, a *fakeAgent) (*dataChannel, chan []byte) {
+	t.Helper()
+	output := make(chan []byte, 100)
+	c, err := openDataChannel(context.Background(), a.url(), "token", "Port", func(payloadType uint32, payload []byte) {
+		output <- append([]byte(nil), payload...)
+	})
+	if err != nil {
+		t.Fatalf("open data channel: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	a.accept()
+	return c, output
+}
+
+func waitDone(t *testing.T, c *dataChannel) {
+	t.Helper()
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("data channel not closed")
+	}
+}
+
+func TestDataChannelHandshake(t *testing.T) {
+	a := newFakeAgent(t)
+	c, _ := openTestDataChannel(t, a)
+
+	resp := a.handshake("Port")
+	if resp.ClientVersion != clientVersion {
+		t.Errorf("client version = %q, want %q", resp.ClientVersion, clientVersion)
+	}
+	if len(resp.Errors) != 0 {
+		t.Errorf("handshake errors = %v, want none", resp.Errors)
+	}
+	if len(resp.ProcessedClientActions) != 1 || resp.ProcessedClientActions[0].ActionStatus != actionSuccess {
+		t.Errorf("processed actions = %+v, want one successful", resp.ProcessedClientActions)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.waitForHandshake(ctx); err != nil {
+		t.Fatalf("wait for handshake: %v", err)
+	}
+	if c.AgentVersion() != testAgentVersion {
+		t.Errorf("agent version = %q, want %q", c.AgentVersion(), testAgentVersion)
+	}
+}
+
+func TestDataChannelHandshakeRejectsKMS(t *testing.T) {
+	a := newFakeAgent(t)
+	c, _ := openTestDataChannel(t, a)
+
+	resp := a.handshake("Port", actionKMSEncryption)
+	if len(resp.ProcessedClientActions) != 2 || resp.ProcessedClientActions[1].ActionStatus != actionFailed {
+		t.Errorf("processed actions = %+v, want KMS encryption failed", resp.ProcessedClientActions)
+	}
+
+	waitDone(t, c)
+	if err := c.Err(); !errors.Is(err, ErrKMSEncryptionUnsupported) {
+		t.Errorf("error = %v, want %v", err, ErrKMSEncryptionUnsupported)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.waitForHandshake(ctx); err == nil {
+		t.Error("wait for handshake succeeded, want error")
+	}
+}
+
+func TestDataChannelOrdersOutput(t *testing.T) {
+	a := newFakeAgent(t)
+	c, output := openTestDataChannel(t, a)
+	a.handshake("Port")
+
+	// 2 arrives before 1, and 1 is resent by the agent; every message is acknowledged, but processed once in order
+	a.sendOutput(2, payloadOutput, []byte("two"))
+	a.receiveAcknowledge(2)
+	a.sendOutput(1, payloadOutput, []byte("one"))
+	a.receiveAcknowledge(1)
+	a.sendOutput(1, payloadOutput, []byte("one"))
+	a.receiveAcknowledge(1)
+	a.sendOutput(3, payloadOutput, []byte("three"))
+	a.receiveAcknowledge(3)
+
+	for _, want := range []string{"one", "two", "three"} {
+		select {
+		case got := <-output:
+			if string(got) != want {
+				t.Fatalf("output = %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no output, want %q", want)
+		}
+	}
+	select {
+	case got := <-output:
+		t.Errorf("unexpected output %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.expected != 4 || len(c.incoming) != 0 {
+		t.Errorf("expected sequence number %d with %d buffered messages, want 4 and none", c.expected, len(c.incoming))
+	}
+}
+
+func TestDataChannelResendsUntilAcknowledged(t *testing.T) {
+	a := newFakeAgent(t)
+	c, _ := openTestDataChannel(t, a)
+	a.handshake("Port")
+
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	msg := a.receive(inputStreamMessage)
+	if msg.SequenceNumber != 1 || string(msg.Payload) != "hello" {
+		t.Fatalf("got %q with sequence number %d, want hello with 1", msg.Payload, msg.SequenceNumber)
+	}
+
+	// without an acknowledgement, the message is sent again after the resend timeout
+	resent := a.receive(inputStreamMessage)
+	if resent.SequenceNumber != 1 || resent.MessageID != msg.MessageID {
+		t.Fatalf("resent sequence number %d, want 1 with the same message ID", resent.SequenceNumber)
+	}
+
+	a.sendAcknowledge(msg)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.lock.Lock()
+		pending := c.outgoing.Len()
+		c.lock.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d messages still waiting for acknowledgement", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(c.window) != 0 {
+		t.Errorf("window holds %d messages, want none", len(c.window))
+	}
+}
+
+func TestDataChannelClose(t *testing.T) {
+	a := newFakeAgent(t)
+	c, _ := openTestDataChannel(t, a)
+	a.handshake("Port")
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	waitDone(t, c)
+	if err := c.Err(); err != nil {
+		t.Errorf("error = %v, want nil after Close", err)
+	}
+	if _, err := c.Write([]byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("write error = %v, want %v", err, net.ErrClosed)
+	}
+	// closing again is a no-op
+	if err := c.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+}
+
+func TestDataChannelClosedByAgent(t *testing.T) {
+	a := newFakeAgent(t)
+	c, _ := openTestDataChannel(t, a)
+	a.handshake("Port")
+
+	payload, _ := json.Marshal(channelClosed{MessageType: channelClosedMessage, Output: "idle timeout"})
+	a.send(newDataChannelMessage(channelClosedMessage, 0, payload))
+
+	waitDone(t, c)
+	err := c.Err()
+	if !errors.Is(err, ErrSessionClosed) || !strings.Contains(err.Error(), "idle timeout") {
+		t.Errorf("error = %v, want %v with the reason", err, ErrSessionClosed)
+	}
+	if _, werr := c.Write([]byte("late")); !errors.Is(werr, ErrSessionClosed) {
+		t.Errorf("write error = %v, want %v", werr, ErrSessionClosed)
+	}
+}
+
+func TestAgentVersionAfter(t *testing.T) {
+	tests := []struct {
+		agent, version string
+		want           bool
+	}{
+		{"3.1.1732.0", multiplexingAgentVersion, true},
+		{"3.0.196.0", multiplexingAgentVersion, false},
+		{"2.3.68.0", terminateSessionFlagAgentVersion, false},
+		{"unknown", terminateSessionFlagAgentVersion, false},
+	}
+	for _, tt := range tests {
+		if got := agentVersionAfter(tt.agent, tt.version); got != tt.want {
+			t.Errorf("agentVersionAfter(%q, %q) = %v, want %v", tt.agent, tt.version, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package ssmclient
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// terminateSessionTimeout bounds the ssm:TerminateSession call made while shutting down.
+const terminateSessionTimeout = 10 * time.Second
+
+// interruptSignals are the signals ending a session gracefully.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT}
+
+// terminationSignals are the signals ending an interactive session. Ctrl-C and Ctrl-\ are not among them, since
+// they are forwarded to the remote shell instead.
+var terminationSignals = []os.Signal{syscall.SIGTERM, syscall.SIGHUP}
+
+// InterruptedError is the error returned if a session was ended by a signal.
+type InterruptedError struct {
+	Signal os.Signal
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("interrupted by %s", e.Signal)
+}
+
+// ExitCode returns the conventional exit code of a process ended by the signal, which is 128 plus the signal number.
+func (e *InterruptedError) ExitCode() int {
+	if sig, ok := e.Signal.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 1
+}
+
+// WithInterrupt returns a copy of parent that is canceled on SIGINT, SIGTERM or SIGQUIT, with an *InterruptedError
+// as cause. Only the first signal is caught, so a second Ctrl-C ends the process immediately if shutting down hangs.
+func WithInterrupt(parent context.Context) (context.Context, context.CancelFunc) {
+	return withSignals(parent, interruptSignals...)
+}
+
+// withSignals returns a copy of parent that is canceled on the first of the signals, with an *InterruptedError as
+// cause.
+func withSignals(parent context.Context, signals ...os.Signal) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, signals...)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			cancel(&InterruptedError{Signal: sig})
+		case <-ctx.Done():
+		}
+		signal.Stop(sigCh)
+	}()
+
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// TerminateSession ends the session on the server side, so it does not linger until it times out.
+func TerminateSession(cfg aws.Config, sessionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), terminateSessionTimeout)
+	defer cancel()
+
+	_, err := ssm.NewFromConfig(cfg).TerminateSession(ctx, &ssm.TerminateSessionInput{SessionId: aws.String(sessionID)})
+	return err
+}
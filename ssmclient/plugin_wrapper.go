@@ -4,8 +4,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
@@ -14,6 +12,7 @@ import (
 	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
 	_ "github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
 	"github.com/google/uuid"
+	"golang.org/x/term"
 )
 
 func PluginSession(cfg aws.Config, input *ssm.StartSessionInput) error {
@@ -22,16 +21,54 @@ func PluginSession(cfg aws.Config, input *ssm.StartSessionInput) error {
 		return err
 	}
 
-	ep, err := ssm.NewDefaultEndpointResolver().ResolveEndpoint(cfg.Region, ssm.EndpointResolverOptions{})
+	return AttachPluginSession(cfg, *input.Target, out)
+}
+
+// InteractivePluginSession starts an interactive session and runs it with AttachInteractivePluginSession.
+func InteractivePluginSession(cfg aws.Config, input *ssm.StartSessionInput) error {
+	out, err := ssm.NewFromConfig(cfg).StartSession(context.Background(), input)
 	if err != nil {
 		return err
 	}
 
+	return AttachInteractivePluginSession(cfg, *input.Target, out)
+}
+
+// AttachPluginSession runs the session-manager-plugin for a port session that was started elsewhere. Target is the
+// target the session was started for. On SIGINT, SIGTERM or SIGQUIT the session is terminated and the process exits.
+func AttachPluginSession(cfg aws.Config, target string, out *ssm.StartSessionOutput) error {
 	// use a signal handler vs. defer since defer operates after an escape from the outer loop
 	// and we can't trust the data channel connection state at that point.  Intercepting signals
 	// means we're probably trying to shutdown somewhere in the outer loop, and there's a good
 	// possibility that the data channel is still valid
-	installSignalHandler()
+	ctx, _ := WithInterrupt(context.Background())
+	installSignalHandler(ctx, cfg, *out.SessionId, nil)
+
+	return executePluginSession(cfg, target, out)
+}
+
+// AttachInteractivePluginSession runs the session-manager-plugin for an interactive session that was started
+// elsewhere, e.g. by ecs:ExecuteCommand. Ctrl-C, Ctrl-Z and Ctrl-\ are forwarded to the remote shell by the plugin.
+// Only SIGTERM and SIGHUP terminate the session, restoring the terminal before exiting, since the plugin puts it
+// into raw mode.
+func AttachInteractivePluginSession(cfg aws.Config, target string, out *ssm.StartSessionOutput) error {
+	restore := func() {}
+	fd := int(os.Stdin.Fd())
+	if state, err := term.GetState(fd); err == nil {
+		restore = func() { term.Restore(fd, state) }
+	}
+
+	ctx, _ := withSignals(context.Background(), terminationSignals...)
+	installSignalHandler(ctx, cfg, *out.SessionId, restore)
+
+	return executePluginSession(cfg, target, out)
+}
+
+func executePluginSession(cfg aws.Config, target string, out *ssm.StartSessionOutput) error {
+	ep, err := ssm.NewDefaultEndpointResolver().ResolveEndpoint(cfg.Region, ssm.EndpointResolverOptions{})
+	if err != nil {
+		return err
+	}
 
 	ssmSession := new(session.Session)
 	ssmSession.SessionId = *out.SessionId
@@ -39,22 +76,28 @@ func PluginSession(cfg aws.Config, input *ssm.StartSessionInput) error {
 	ssmSession.TokenValue = *out.TokenValue
 	ssmSession.Endpoint = ep.URL
 	ssmSession.ClientId = uuid.NewString()
-	ssmSession.TargetId = *input.Target
+	ssmSession.TargetId = target
 	ssmSession.DataChannel = &datachannel.DataChannel{}
 
 	return ssmSession.Execute(log.Logger(false, ssmSession.ClientId))
 }
 
-func installSignalHandler() {
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM)
+// installSignalHandler terminates the session and exits once ctx is canceled by a signal. The session-manager-plugin
+// cannot be stopped in-process, so exiting is the only way out; the exit code tells which signal ended the session.
+// If set, restore is called before exiting.
+func installSignalHandler(ctx context.Context, cfg aws.Config, sessionID string, restore func()) {
 	go func() {
-		sig := <-sigCh
-		fmt.Printf("Got signal: %s, shutting down...", sig.String())
+		<-ctx.Done()
+		interrupted := context.Cause(ctx).(*InterruptedError)
+		if restore != nil {
+			restore()
+		}
+		fmt.Fprintf(os.Stderr, "Got signal: %s, terminating session %s...\n", interrupted.Signal, sessionID)
 
-		//_ = c..TerminateSession()
-		//_ = c.Close(log.Logger(false, ""))
+		if err := TerminateSession(cfg, sessionID); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to terminate session %s, %v\n", sessionID, err)
+		}
 
-		os.Exit(0)
+		os.Exit(interrupted.ExitCode())
 	}()
 }
@@ -0,0 +1,427 @@
+package ssmclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/xtaci/smux"
+)
+
+// portSessionType is the session type the agent announces for port forwarding sessions.
+const portSessionType = "Port"
+
+var (
+	// ErrConnectToPort is reported on the error channel if the agent could not connect to the remote port.
+	ErrConnectToPort = errors.New("connection to destination port failed, check SSM Agent logs")
+	// ErrKeepAliveUnsupported is reported on the error channel if a keepalive was requested, but the agent does not
+	// support multiplexing, which keepalive frames require.
+	ErrKeepAliveUnsupported = errors.New("keepalive not supported by the SSM agent, update it to a version newer than " +
+		multiplexingAgentVersion + " to keep the session from running into the idle timeout")
+)
+
+// PortForwarder is a running port forwarding session, implemented natively on top of the SSM data channel instead of
+// the session-manager-plugin. It accepts connections on the local port until it is closed or the session ends.
+//
+// Agents newer than 3.0.196.0 multiplex any number of local connections over the session. Older agents only support a
+// single connection at a time, further connections wait until the previous one is closed. Keepalive frames are only
+// sent on multiplexed sessions, where they are dropped by the agent instead of reaching the remote port.
+type PortForwarder struct {
+	// SessionID is the ID of the SSM session.
+	SessionID string
+	// LocalPort is the local port the forwarder listens on.
+	LocalPort int
+
+	listener net.Listener
+	channel  *dataChannel
+	mux      *smux.Session
+	muxPipe  net.Conn
+	conn     net.Conn
+	connLock sync.Mutex
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	errs     chan error
+	errsLock sync.Mutex
+	done     chan struct{}
+	once     sync.Once
+	err      error
+
+	// terminate ends the session on the server side, if the forwarder started it
+	terminate func() error
+}
+
+// PortForwardingSession starts a native port forwarding session and blocks until it ends. It is the counterpart of
+// PortPluginSession without the session-manager-plugin. On SIGINT, SIGTERM or SIGQUIT the local port is closed and the
+// session is terminated, and an *InterruptedError is returned. Sessions requiring KMS encryption, which the native
+// client does not implement, are handed over to PortPluginSession.
+func PortForwardingSession(cfg aws.Config, opts *PortForwardingInput) error {
+	ctx, cancel := WithInterrupt(context.Background())
+	defer cancel()
+
+	p, err := StartPortForwarding(ctx, cfg, opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if errors.Is(err, ErrKMSEncryptionUnsupported) {
+			// the signal handler of the plugin session takes over
+			cancel()
+			fmt.Fprintln(os.Stderr, "The session requires KMS encryption, using the session-manager-plugin.")
+			if opts.KeepAlive > 0 {
+				fmt.Fprintln(os.Stderr, "Warning: keepalive is not supported by the session-manager-plugin, the session may run into the idle timeout.")
+			}
+			return PortPluginSession(cfg, opts)
+		}
+		return err
+	}
+
+	fmt.Printf("Port %d opened for sessionId %s.\nWaiting for connections...\n", p.LocalPort, p.SessionID)
+	go func() {
+		for err := range p.Errors() {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+
+	select {
+	case <-p.Done():
+		return p.Err()
+	case <-ctx.Done():
+	}
+
+	fmt.Printf("\nTerminating session %s...\n", p.SessionID)
+	if err := p.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to terminate session %s, %v\n", p.SessionID, err)
+	}
+	return context.Cause(ctx)
+}
+
+// StartPortForwarding starts an AWS-StartPortForwardingSessionToRemoteHost session and returns once the local port
+// accepts connections. If LocalPort is not set, a random port is used and stored in opts. Closing the returned
+// forwarder terminates the session with ssm:TerminateSession.
+func StartPortForwarding(ctx context.Context, cfg aws.Config, opts *PortForwardingInput) (*PortForwarder, error) {
+	in := &ssm.StartSessionInput{
+		DocumentName: aws.String("AWS-StartPortForwardingSessionToRemoteHost"),
+		Target:       aws.String(opts.Target),
+		Parameters: map[string][]string{
+			"portNumber": {strconv.Itoa(opts.RemotePort)},
+			"host":       {opts.Host},
+		},
+	}
+
+	out, err := ssm.NewFromConfig(cfg).StartSession(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := NewPortForwarder(ctx, out, opts)
+	if err != nil {
+		_ = TerminateSession(cfg, aws.ToString(out.SessionId))
+		return nil, err
+	}
+
+	p.terminate = func() error {
+		return TerminateSession(cfg, p.SessionID)
+	}
+	return p, nil
+}
+
+// NewPortForwarder connects to the data channel of an already started port forwarding session and starts listening on
+// the local port. Only the StreamUrl, TokenValue and SessionId of the session are used, so the forwarder can be
+// pointed at any websocket server speaking the data channel protocol.
+func NewPortForwarder(ctx context.Context, session *ssm.StartSessionOutput, opts *PortForwardingInput) (*PortForwarder, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(opts.LocalPort)))
+	if err != nil {
+		return nil, err
+	}
+	opts.LocalPort = listener.Addr().(*net.TCPAddr).Port
+
+	p := &PortForwarder{
+		SessionID: aws.ToString(session.SessionId),
+		LocalPort: opts.LocalPort,
+		listener:  listener,
+		errs:      make(chan error, 16),
+		done:      make(chan struct{}),
+	}
+
+	p.channel, err = openDataChannel(ctx, aws.ToString(session.StreamUrl), aws.ToString(session.TokenValue), portSessionType, p.handleOutput)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	if err := p.channel.waitForHandshake(ctx); err != nil {
+		p.channel.Close()
+		listener.Close()
+		return nil, err
+	}
+
+	agentVersion := p.channel.AgentVersion()
+	if agentVersionAfter(agentVersion, multiplexingAgentVersion) {
+		if err := p.startMux(agentVersion, opts.KeepAlive); err != nil {
+			p.channel.Close()
+			listener.Close()
+			return nil, err
+		}
+		go p.acceptMux()
+	} else {
+		if opts.KeepAlive > 0 {
+			p.report(ErrKeepAliveUnsupported)
+		}
+		go p.acceptBasic()
+	}
+
+	go func() {
+		<-p.channel.Done()
+		p.shutdown(p.channel.Err())
+	}()
+	return p, nil
+}
+
+// BytesIn returns the number of bytes received from the remote port.
+func (p *PortForwarder) BytesIn() int64 {
+	return p.bytesIn.Load()
+}
+
+// BytesOut returns the number of bytes sent to the remote port.
+func (p *PortForwarder) BytesOut() int64 {
+	return p.bytesOut.Load()
+}
+
+// Errors returns a channel reporting errors that do not end the session, like failed connections to the remote port.
+// It is closed when the session ends. Errors are dropped if the channel is not drained.
+func (p *PortForwarder) Errors() <-chan error {
+	return p.errs
+}
+
+// Done returns a channel that is closed when the session ended.
+func (p *PortForwarder) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the reason the session ended. It is nil while the session is running and after Close.
+func (p *PortForwarder) Err() error {
+	select {
+	case <-p.done:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the session ended and returns the reason.
+func (p *PortForwarder) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Close terminates the session, stops listening on the local port and closes all forwarded connections. Sessions
+// started by StartPortForwarding are terminated with ssm:TerminateSession as well, so they do not linger until the
+// idle timeout.
+func (p *PortForwarder) Close() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+
+	if agentVersionAfter(p.channel.AgentVersion(), terminateSessionFlagAgentVersion) {
+		_ = p.channel.sendFlag(flagTerminateSession)
+	}
+	p.shutdown(nil)
+
+	if p.terminate != nil {
+		return p.terminate()
+	}
+	return nil
+}
+
+func (p *PortForwarder) shutdown(err error) {
+	p.once.Do(func() {
+		p.err = err
+		p.listener.Close()
+		p.channel.Close()
+		p.connLock.Lock()
+		if p.mux != nil {
+			p.mux.Close()
+			p.muxPipe.Close()
+		}
+		if p.conn != nil {
+			p.conn.Close()
+		}
+		p.connLock.Unlock()
+		close(p.done)
+
+		p.errsLock.Lock()
+		close(p.errs)
+		p.errsLock.Unlock()
+	})
+}
+
+// report passes a non-fatal error to the error channel without blocking.
+func (p *PortForwarder) report(err error) {
+	p.errsLock.Lock()
+	defer p.errsLock.Unlock()
+
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	select {
+	case p.errs <- err:
+	default:
+	}
+}
+
+// handleOutput receives the payloads sent by the agent.
+func (p *PortForwarder) handleOutput(payloadType uint32, payload []byte) {
+	switch payloadType {
+	case payloadOutput:
+		p.connLock.Lock()
+		conn, muxed := p.conn, p.mux != nil
+		if muxed {
+			conn = p.muxPipe
+		}
+		p.connLock.Unlock()
+
+		if conn == nil {
+			return
+		}
+		// multiplexed traffic is counted per stream, since the payload includes smux frame headers
+		n, _ := conn.Write(payload)
+		if !muxed {
+			p.bytesIn.Add(int64(n))
+		}
+	case payloadFlag:
+		if len(payload) == 4 && binary.BigEndian.Uint32(payload) == flagConnectToPortError {
+			p.report(ErrConnectToPort)
+		}
+	}
+}
+
+// startMux sets up an smux client session whose frames are carried by the data channel. With a keepAlive interval,
+// smux NOP frames are sent through the session, which count as activity for the idle timeout of the session.
+func (p *PortForwarder) startMux(agentVersion string, keepAlive time.Duration) error {
+	local, remote := net.Pipe()
+
+	cfg := smux.DefaultConfig()
+	cfg.KeepAliveDisabled = agentVersionAfter(agentVersion, smuxKeepAliveDisabledVersion)
+	if keepAlive > 0 {
+		cfg.KeepAliveInterval = keepAlive
+		if cfg.KeepAliveDisabled {
+			// newer agents do not send NOP frames themselves, so a quiet session must not be closed for lack of them
+			cfg.KeepAliveDisabled = false
+			cfg.KeepAliveTimeout = time.Duration(math.MaxInt64)
+		} else if cfg.KeepAliveTimeout < keepAlive {
+			// older agents do send them, which keeps detecting a dead agent with the default timeout
+			cfg.KeepAliveTimeout = keepAlive
+		}
+	}
+	mux, err := smux.Client(local, cfg)
+	if err != nil {
+		return err
+	}
+
+	p.connLock.Lock()
+	p.mux = mux
+	p.muxPipe = remote
+	p.connLock.Unlock()
+	go func() {
+		buf := make([]byte, streamDataPayloadSize)
+		for {
+			n, err := remote.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := p.channel.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// acceptMux forwards every local connection over its own smux stream.
+func (p *PortForwarder) acceptMux() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			p.shutdown(p.channel.Err())
+			return
+		}
+
+		stream, err := p.mux.OpenStream()
+		if err != nil {
+			conn.Close()
+			p.report(err)
+			continue
+		}
+
+		go func() {
+			done := make(chan struct{})
+			go func() {
+				n, _ := io.Copy(stream, conn)
+				p.bytesOut.Add(n)
+				stream.Close()
+				close(done)
+			}()
+			n, _ := io.Copy(conn, stream)
+			p.bytesIn.Add(n)
+			conn.Close()
+			<-done
+		}()
+	}
+}
+
+// acceptBasic forwards one local connection at a time. The agent is told to disconnect from the remote port whenever
+// the local connection is closed, so the next connection starts with a fresh remote connection.
+func (p *PortForwarder) acceptBasic() {
+	buf := make([]byte, streamDataPayloadSize)
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			p.shutdown(p.channel.Err())
+			return
+		}
+
+		p.connLock.Lock()
+		p.conn = conn
+		p.connLock.Unlock()
+
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				if _, err := p.channel.Write(buf[:n]); err != nil {
+					break
+				}
+				p.bytesOut.Add(int64(n))
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		p.connLock.Lock()
+		p.conn = nil
+		p.connLock.Unlock()
+		conn.Close()
+
+		if err := p.channel.sendFlag(flagDisconnectToPort); err != nil {
+			p.shutdown(p.channel.Err())
+			return
+		}
+	}
+}
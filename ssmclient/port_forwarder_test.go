@@ -0,0 +1,338 @@
+package ssmclient
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/xtaci/smux"
+)
+
+// basicAgentVersion is an agent without multiplexing support.
+const basicAgentVersion = "3.0.161.0"
+
+// serve answers the input of the client after the handshake: stream data is acknowledged and passed to data once,
+// flags are passed to the returned channel.
+func (a *fakeAgent) serve(data func(payload []byte)) <-chan uint32 {
+	flags := make(chan uint32, 16)
+	a.nextSequence = 1
+	go func() {
+		// the handshake response was sequence number 0
+		expected := int64(1)
+		for msg := range a.messages {
+			if msg.MessageType != inputStreamMessage {
+				continue
+			}
+			// the client may have closed the channel right after sending, e.g. the terminate flag
+			_ = a.write(acknowledgement(msg))
+			if msg.SequenceNumber != expected {
+				// resent after the acknowledgement got delayed
+				continue
+			}
+			expected++
+
+			switch msg.PayloadType {
+			case payloadOutput:
+				data(msg.Payload)
+			case payloadFlag:
+				flags <- binary.BigEndian.Uint32(msg.Payload)
+			}
+		}
+	}()
+	return flags
+}
+
+// output sends stream data to the client with the next sequence number.
+func (a *fakeAgent) output(payloadType uint32, payload []byte) error {
+	a.writeLock.Lock()
+	msg := newDataChannelMessage(outputStreamMessage, payloadType, payload)
+	msg.SequenceNumber = a.nextSequence
+	a.nextSequence++
+	a.writeLock.Unlock()
+	return a.write(msg)
+}
+
+// serveMux runs an smux server on top of the session, echoing every stream, like the agent forwarding to an echo
+// server.
+func (a *fakeAgent) serveMux(t *testing.T) <-chan uint32 {
+	agentSide, muxSide := net.Pipe()
+	t.Cleanup(func() { agentSide.Close() })
+
+	cfg := smux.DefaultConfig()
+	cfg.KeepAliveDisabled = true
+	server, err := smux.Server(muxSide, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	go func() {
+		for {
+			stream, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(stream, stream)
+				stream.Close()
+			}()
+		}
+	}()
+
+	go func() {
+		buf := make([]byte, streamDataPayloadSize)
+		for {
+			n, err := agentSide.Read(buf)
+			if err != nil {
+				return
+			}
+			if err := a.output(payloadOutput, append([]byte(nil), buf[:n]...)); err != nil {
+				return
+			}
+		}
+	}()
+
+	return a.serve(func(payload []byte) {
+		_, _ = agentSide.Write(payload)
+	})
+}
+
+// serveEcho echoes stream data, like an agent without multiplexing forwarding to an echo server.
+func (a *fakeAgent) serveEcho() <-chan uint32 {
+	return a.serve(func(payload []byte) {
+		_ = a.output(payloadOutput, payload)
+	})
+}
+
+// startTestForwarder connects a port forwarder on a free local port to the agent and completes the handshake.
+func startTestForwarder(t *testing.T, a *fakeAgent, opts *PortForwardingInput) *PortForwarder {
+	t.Helper()
+
+	type result struct {
+		p   *PortForwarder
+		err error
+	}
+	started := make(chan result, 1)
+	go func() {
+		session := &ssm.StartSessionOutput{
+			SessionId:  aws.String("session-id"),
+			StreamUrl:  aws.String(a.url()),
+			TokenValue: aws.String("token"),
+		}
+		p, err := NewPortForwarder(context.Background(), session, opts)
+		started <- result{p, err}
+	}()
+
+	a.accept()
+	a.handshake(portSessionType)
+
+	select {
+	case r := <-started:
+		if r.err != nil {
+			t.Fatalf("start port forwarder: %v", r.err)
+		}
+		t.Cleanup(func() { r.p.Close() })
+		return r.p
+	case <-time.After(5 * time.Second):
+		t.Fatal("port forwarder not started")
+	}
+	return nil
+}
+
+func dialForwarder(t *testing.T, p *PortForwarder) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(p.LocalPort)), 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial local port: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// eventually waits until condition is met.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func receiveFlag(t *testing.T, flags <-chan uint32, want uint32) {
+	t.Helper()
+	select {
+	case flag := <-flags:
+		if flag != want {
+			t.Errorf("flag = %d, want %d", flag, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("flag %d not received", want)
+	}
+}
+
+func TestPortForwarderMultiplexed(t *testing.T) {
+	a := newFakeAgent(t)
+	p := startTestForwarder(t, a, &PortForwardingInput{})
+	a.serveMux(t)
+
+	// several connections are forwarded at the same time over their own streams
+	messages := []string{"hello", "multiplexed world"}
+	var wg sync.WaitGroup
+	for _, message := range messages {
+		conn := dialForwarder(t, p)
+		wg.Add(1)
+		go func(conn net.Conn, message string) {
+			defer wg.Done()
+			if _, err := conn.Write([]byte(message)); err != nil {
+				t.Errorf("write: %v", err)
+				return
+			}
+			got := make([]byte, len(message))
+			if _, err := io.ReadFull(conn, got); err != nil {
+				t.Errorf("read: %v", err)
+			}
+			if string(got) != message {
+				t.Errorf("echo = %q, want %q", got, message)
+			}
+			// like the session-manager-plugin, the stream is closed with the local connection, which counts the bytes
+			conn.Close()
+		}(conn, message)
+	}
+	wg.Wait()
+
+	want := int64(len(messages[0]) + len(messages[1]))
+	eventually(t, "byte counters", func() bool { return p.BytesOut() == want && p.BytesIn() == want })
+}
+
+func TestPortForwarderBasic(t *testing.T) {
+	a := newFakeAgent(t)
+	a.agentVersion = basicAgentVersion
+	p := startTestForwarder(t, a, &PortForwardingInput{})
+	flags := a.serveEcho()
+
+	for i, message := range []string{"ping", "pong!"} {
+		conn := dialForwarder(t, p)
+		if _, err := conn.Write([]byte(message)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		got := make([]byte, len(message))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(got) != message {
+			t.Errorf("echo = %q, want %q", got, message)
+		}
+
+		// the agent disconnects from the remote port when the local connection is closed, making room for the next
+		conn.Close()
+		receiveFlag(t, flags, flagDisconnectToPort)
+
+		want := int64(len("ping"))
+		if i == 1 {
+			want += int64(len("pong!"))
+		}
+		eventually(t, "byte counters", func() bool { return p.BytesOut() == want && p.BytesIn() == want })
+	}
+}
+
+func TestPortForwarderBasicKeepAlive(t *testing.T) {
+	a := newFakeAgent(t)
+	a.agentVersion = basicAgentVersion
+	p := startTestForwarder(t, a, &PortForwardingInput{KeepAlive: time.Minute})
+	a.serveEcho()
+
+	// keepalive frames need multiplexing, so the request is not silently ignored
+	select {
+	case err := <-p.Errors():
+		if !errors.Is(err, ErrKeepAliveUnsupported) {
+			t.Errorf("error = %v, want %v", err, ErrKeepAliveUnsupported)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error reported")
+	}
+}
+
+func TestPortForwarderConnectToPortError(t *testing.T) {
+	a := newFakeAgent(t)
+	p := startTestForwarder(t, a, &PortForwardingInput{})
+	a.serveMux(t)
+
+	if err := a.output(payloadFlag, flagPayload(flagConnectToPortError)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-p.Errors():
+		if !errors.Is(err, ErrConnectToPort) {
+			t.Errorf("error = %v, want %v", err, ErrConnectToPort)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error reported")
+	}
+	// the session keeps running
+	if p.Err() != nil {
+		t.Errorf("session ended with %v", p.Err())
+	}
+}
+
+func TestPortForwarderClose(t *testing.T) {
+	a := newFakeAgent(t)
+	p := startTestForwarder(t, a, &PortForwardingInput{})
+	flags := a.serveMux(t)
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	receiveFlag(t, flags, flagTerminateSession)
+
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not done after close")
+	}
+	if err := p.Err(); err != nil {
+		t.Errorf("error = %v, want nil after Close", err)
+	}
+	if conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(p.LocalPort)), time.Second); err == nil {
+		conn.Close()
+		t.Error("local port still accepts connections after close")
+	}
+}
+
+func TestPortForwarderClosedByAgent(t *testing.T) {
+	a := newFakeAgent(t)
+	p := startTestForwarder(t, a, &PortForwardingInput{})
+	a.serveMux(t)
+
+	payload, _ := json.Marshal(channelClosed{MessageType: channelClosedMessage, Output: "idle timeout"})
+	a.send(newDataChannelMessage(channelClosedMessage, 0, payload))
+
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not done after the agent closed it")
+	}
+	if err := p.Wait(); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("error = %v, want %v", err, ErrSessionClosed)
+	}
+	if _, ok := <-p.Errors(); ok {
+		t.Error("error channel not closed")
+	}
+	if conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(p.LocalPort)), time.Second); err == nil {
+		conn.Close()
+		t.Error("local port still accepts connections after the session ended")
+	}
+}
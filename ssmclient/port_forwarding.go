@@ -1,7 +1,10 @@
 package ssmclient
 
 import (
+	"errors"
+	"net"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
@@ -11,14 +14,28 @@ import (
 // Target is the EC2 instance ID to establish the session with.
 // RemotePort is the port on the EC2 instance to connect to.
 // LocalPort is the port on the local host to listen to.  If not provided, a random port will be used.
+// KeepAlive is the interval in which the native client sends keepalive frames through the session, which keeps it
+// from running into the idle timeout without sending traffic to the remote port. If not provided, the agent decides.
 type PortForwardingInput struct {
 	Target     string
 	RemotePort int
 	LocalPort  int
 	Host       string
+	KeepAlive  time.Duration
 }
 
+// ErrNoFreePort is the error returned if no free local port could be found.
+var ErrNoFreePort = errors.New("no free local port found")
+
 func PortPluginSession(cfg aws.Config, opts *PortForwardingInput) error {
+	if opts.LocalPort == 0 {
+		port, err := FreeLocalPort()
+		if err != nil {
+			return err
+		}
+		opts.LocalPort = port
+	}
+
 	in := &ssm.StartSessionInput{
 		DocumentName: aws.String("AWS-StartPortForwardingSessionToRemoteHost"),
 		Target:       aws.String(opts.Target),
@@ -31,3 +48,34 @@ func PortPluginSession(cfg aws.Config, opts *PortForwardingInput) error {
 
 	return PluginSession(cfg, in)
 }
+
+// LocalPortAvailable checks whether a listener can be opened on the local port, which is what the port forwarding
+// session will do.
+func LocalPortAvailable(port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	_ = l.Close()
+	return true
+}
+
+// NextFreeLocalPort returns the first available local port, starting the search at port.
+func NextFreeLocalPort(port int) (int, error) {
+	for ; port > 0 && port <= 65535; port++ {
+		if LocalPortAvailable(port) {
+			return port, nil
+		}
+	}
+	return 0, ErrNoFreePort
+}
+
+// FreeLocalPort returns an ephemeral local port chosen by the operating system.
+func FreeLocalPort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,26 @@
+package ssmclient
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	_ "github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/shellsession"
+)
+
+// ShellPluginSession starts an interactive shell on the target EC2 instance using the session-manager-plugin, which
+// puts the terminal into raw mode and propagates window size changes. If command is set, the command is run
+// interactively with the AWS-StartInteractiveCommand document instead of a shell. Ctrl-C reaches the remote shell.
+// The plugin exits the process when the session ends.
+func ShellPluginSession(cfg aws.Config, target string, command string) error {
+	in := &ssm.StartSessionInput{
+		Target: aws.String(target),
+	}
+
+	if command != "" {
+		in.DocumentName = aws.String("AWS-StartInteractiveCommand")
+		in.Parameters = map[string][]string{
+			"command": {command},
+		}
+	}
+
+	return InteractivePluginSession(cfg, in)
+}